@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+)
+
+// AuditOutcome is the result of an authentication attempt.
+type AuditOutcome string
+
+const (
+	AuditAccepted AuditOutcome = "accepted"
+	AuditRejected AuditOutcome = "rejected"
+)
+
+// AuditRecord describes a single handshake.
+type AuditRecord struct {
+	// Time is when the handshake started.
+	Time time.Time `json:"time"`
+
+	// Role is the part the local side played, "client" or "server".
+	Role string `json:"role"`
+
+	// RemoteAddr is the network address of the remote.
+	RemoteAddr string `json:"remote_addr"`
+
+	// RemoteKey is the long-term key of the remote as an @...ed25519 ref.
+	// A server only knows it once the client authenticated, a client always
+	// records the key it expected.
+	RemoteKey string `json:"remote_key,omitempty"`
+
+	// AppKey is a fingerprint of the app key, see AppKeyFingerprint.
+	AppKey string `json:"app_key"`
+
+	Outcome AuditOutcome `json:"outcome"`
+
+	// Stage is the step of the handshake that failed, empty if it didn't get that far.
+	Stage string `json:"stage,omitempty"`
+
+	// Reason is the error a rejected handshake failed with.
+	Reason string `json:"reason,omitempty"`
+
+	// Duration is the time the handshake took, in nanoseconds when encoded as JSON.
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// AuditSink receives a record of every handshake. Record is called before
+// the connection is handed to the application. If it fails for an accepted
+// handshake, the connection is closed and the error returned from the
+// ConnWrapper, so no connection goes unrecorded.
+type AuditSink interface {
+	Record(AuditRecord) error
+}
+
+// WithAudit makes the Client or Server send a record of every handshake to sink.
+func WithAudit(sink AuditSink) Option {
+	return func(o *options) error {
+		o.audit = sink
+		return nil
+	}
+}
+
+// AppKeyFingerprint returns the identifier of an app key that is used in
+// audit records. It doesn't reveal the app key.
+func AppKeyFingerprint(appKey []byte) string {
+	sum := sha256.Sum256(appKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// JSONAuditWriter is an AuditSink that writes one JSON object per line.
+type JSONAuditWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONAuditWriter returns a JSONAuditWriter that writes to w.
+func NewJSONAuditWriter(w io.Writer) *JSONAuditWriter {
+	return &JSONAuditWriter{enc: json.NewEncoder(w)}
+}
+
+// Record writes rec as a line of JSON.
+func (w *JSONAuditWriter) Record(rec AuditRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(rec)
+}
+
+// auditRecorder collects the record of a single handshake
+type auditRecorder struct {
+	secrethandshake.NopHooks
+
+	sink  AuditSink
+	start time.Time
+	rec   AuditRecord
+}
+
+func (o options) newAuditRecorder(conn net.Conn, role secrethandshake.Role, appKey, remote []byte, start time.Time) *auditRecorder {
+	if o.audit == nil {
+		return nil
+	}
+
+	a := &auditRecorder{
+		sink:  o.audit,
+		start: start,
+		rec: AuditRecord{
+			Time:   start,
+			Role:   role.String(),
+			AppKey: AppKeyFingerprint(appKey),
+		},
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		a.rec.RemoteAddr = addr.String()
+	}
+	if remote != nil {
+		a.rec.RemoteKey = Addr{remote}.String()
+	}
+	return a
+}
+
+func (a *auditRecorder) HandshakeSucceeded(remote ed25519.PublicKey) {
+	a.rec.RemoteKey = Addr{remote}.String()
+}
+
+func (a *auditRecorder) HandshakeFailed(stage secrethandshake.Stage, err error) {
+	a.rec.Stage = stage.String()
+
+	// a refused client was authenticated, so its key is known
+	var unauthorized secrethandshake.ErrUnauthorized
+	if errors.As(err, &unauthorized) {
+		a.rec.RemoteKey = Addr{unauthorized.Remote}.String()
+	}
+}
+
+// finish sends the record with the outcome of the handshake to the sink. It may be called on a nil recorder.
+func (a *auditRecorder) finish(err error) error {
+	if a == nil {
+		return nil
+	}
+
+	a.rec.Duration = time.Since(a.start)
+	if err != nil {
+		a.rec.Outcome = AuditRejected
+		a.rec.Reason = err.Error()
+	} else {
+		a.rec.Outcome = AuditAccepted
+	}
+	return a.sink.Record(a.rec)
+}
@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+// lockedBuffer is a bytes.Buffer that can be shared between goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) records(t *testing.T) []AuditRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var recs []AuditRecord
+	dec := json.NewDecoder(&b.buf)
+	for dec.More() {
+		var rec AuditRecord
+		require.NoError(t, dec.Decode(&rec))
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestAuditAccepted(t *testing.T) {
+	r := require.New(t)
+
+	var srvLog, cliLog lockedBuffer
+	srv, cli := mkConnPair(t,
+		[]Option{WithAudit(NewJSONAuditWriter(&srvLog))},
+		[]Option{WithAudit(NewJSONAuditWriter(&cliLog))},
+	)
+
+	srvRecs := srvLog.records(t)
+	r.Len(srvRecs, 1)
+	rec := srvRecs[0]
+	r.Equal(AuditAccepted, rec.Outcome)
+	r.Equal("server", rec.Role)
+	r.Equal(cli.conn.LocalAddr().String(), rec.RemoteAddr)
+	r.Equal(Addr{clientKeys.Public}.String(), rec.RemoteKey)
+	r.Equal(AppKeyFingerprint(appKey), rec.AppKey)
+	r.Empty(rec.Stage)
+	r.Empty(rec.Reason)
+	r.True(rec.Duration > 0)
+	r.False(rec.Time.IsZero())
+
+	cliRecs := cliLog.records(t)
+	r.Len(cliRecs, 1)
+	r.Equal(AuditAccepted, cliRecs[0].Outcome)
+	r.Equal("client", cliRecs[0].Role)
+	r.Equal(Addr{serverKeys.Public}.String(), cliRecs[0].RemoteKey)
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestAuditRejected(t *testing.T) {
+	r := require.New(t)
+
+	var srvLog, cliLog lockedBuffer
+
+	s, err := NewServer(*serverKeys, appKey, WithAudit(NewJSONAuditWriter(&srvLog)))
+	r.NoError(err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+	defer l.Close()
+
+	acceptErrc := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		acceptErrc <- err
+	}()
+
+	c, err := NewClient(*clientKeys, appKey, WithAudit(NewJSONAuditWriter(&cliLog)))
+	r.NoError(err)
+
+	// the client expects another server
+	_, err = netwrap.Dial(netwrap.GetAddr(l.Addr(), "tcp"), c.ConnWrapper(clientKeys.Public))
+	r.Error(err)
+	r.Error(<-acceptErrc)
+
+	srvRecs := srvLog.records(t)
+	r.Len(srvRecs, 1)
+	r.Equal(AuditRejected, srvRecs[0].Outcome)
+	r.Equal("verify client auth", srvRecs[0].Stage)
+	r.Empty(srvRecs[0].RemoteKey, "unauthenticated key was recorded")
+	r.NotEmpty(srvRecs[0].Reason)
+
+	cliRecs := cliLog.records(t)
+	r.Len(cliRecs, 1)
+	r.Equal(AuditRejected, cliRecs[0].Outcome)
+	r.Equal("receive server accept", cliRecs[0].Stage)
+	r.Equal(Addr{clientKeys.Public}.String(), cliRecs[0].RemoteKey)
+}
+
+func TestAuditUnauthorized(t *testing.T) {
+	r := require.New(t)
+
+	var srvLog lockedBuffer
+
+	denied := errors.New("not on the list")
+	s, err := NewServer(*serverKeys, appKey,
+		WithAudit(NewJSONAuditWriter(&srvLog)),
+		WithAuthorizer(func(ed25519.PublicKey) error { return denied }),
+	)
+	r.NoError(err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+	defer l.Close()
+
+	acceptErrc := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		acceptErrc <- err
+	}()
+
+	_, err = dial(t, l)
+	r.Error(err)
+	r.Error(<-acceptErrc)
+
+	// the refused client is on record with its authenticated key
+	srvRecs := srvLog.records(t)
+	r.Len(srvRecs, 1)
+	r.Equal(AuditRejected, srvRecs[0].Outcome)
+	r.Equal("verify client auth", srvRecs[0].Stage)
+	r.Equal(Addr{clientKeys.Public}.String(), srvRecs[0].RemoteKey)
+	r.Contains(srvRecs[0].Reason, denied.Error())
+}
+
+type failingSink struct{}
+
+func (failingSink) Record(AuditRecord) error { return errors.New("disk full") }
+
+func TestAuditSinkFailure(t *testing.T) {
+	r := require.New(t)
+
+	s, err := NewServer(*serverKeys, appKey)
+	r.NoError(err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+	defer l.Close()
+
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	c, err := NewClient(*clientKeys, appKey, WithAudit(failingSink{}))
+	r.NoError(err)
+
+	// connections that can't be recorded are refused
+	_, err = netwrap.Dial(netwrap.GetAddr(l.Addr(), "tcp"), c.ConnWrapper(serverKeys.Public))
+	r.Error(err)
+	r.Contains(err.Error(), "disk full")
+}
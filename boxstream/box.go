@@ -8,11 +8,8 @@
 package boxstream
 
 import (
-	"encoding/binary"
 	"io"
 	"sync"
-
-	"golang.org/x/crypto/nacl/secretbox"
 )
 
 const (
@@ -29,56 +26,78 @@ var goodbye [18]byte
 type Boxer struct {
 	l      sync.Mutex
 	w      io.Writer
-	secret *[32]byte
-	nonce  *[24]byte
+	sealer Sealer
+	buf    []byte
+	wiped  bool
+
+	counters counters
 }
 
-// WriteMessage writes a boxstream packet to the underlying writer. len(msg)
-// must not exceed MaxSegmentSize.
+// WriteMessage writes a boxstream packet to the underlying writer. If len(msg)
+// exceeds MaxSegmentSize, nothing is written and ErrSegmentTooLarge is returned.
 func (b *Boxer) WriteMessage(msg []byte) error {
-	if len(msg) > MaxSegmentSize {
-		panic("message exceeds maximum segment size")
-	}
 	b.l.Lock()
 	defer b.l.Unlock()
+	if b.wiped {
+		return ErrWiped
+	}
 
-	headerNonce := *b.nonce
-	increment(b.nonce)
-	bodyNonce := *b.nonce
-	increment(b.nonce)
-
-	// construct body box
-	bodyBox := secretbox.Seal(nil, msg, &bodyNonce, b.secret)
-	bodyMAC, body := bodyBox[:secretbox.Overhead], bodyBox[secretbox.Overhead:]
-
-	// construct header box
-	header := make([]byte, 2+secretbox.Overhead)
-	binary.BigEndian.PutUint16(header[:2], uint16(len(msg)))
-	copy(header[2:], bodyMAC)
-	headerBox := secretbox.Seal(nil, header, &headerNonce, b.secret)
+	frame, err := b.sealer.Seal(b.buf[:0], msg)
+	if err != nil {
+		return err
+	}
+	b.buf = frame
 
 	// write header + body
-	if _, err := b.w.Write(headerBox); err != nil {
+	if _, err = b.w.Write(frame); err != nil {
 		return err
 	}
-	_, err := b.w.Write(body)
-	return err
+	b.counters.frame(len(msg))
+	return nil
 }
 
 // WriteGoodbye writes the 'goodbye' protocol message to the underlying writer.
 func (b *Boxer) WriteGoodbye() error {
 	b.l.Lock()
 	defer b.l.Unlock()
-	_, err := b.w.Write(secretbox.Seal(nil, goodbye[:], b.nonce, b.secret))
-	return err
+	if b.wiped {
+		return ErrWiped
+	}
+	if _, err := b.w.Write(b.sealer.SealGoodbye(nil)); err != nil {
+		return err
+	}
+	b.counters.goodbye()
+	return nil
+}
+
+// Wipe overwrites the key and nonce that were passed to NewBoxer and the
+// last encrypted frame. Writes fail with ErrWiped afterwards.
+func (b *Boxer) Wipe() {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.wiped {
+		return
+	}
+	b.wiped = true
+
+	b.sealer.wipe()
+	b.buf = b.buf[:cap(b.buf)]
+	for i := range b.buf {
+		b.buf[i] = 0
+	}
+	b.buf = nil
+}
+
+// Stats returns the counters of the messages written so far.
+func (b *Boxer) Stats() Stats {
+	return b.counters.get()
 }
 
 // NewBoxer returns a Boxer that writes encrypted messages to w.
 func NewBoxer(w io.Writer, nonce *[24]byte, secret *[32]byte) *Boxer {
 	return &Boxer{
 		w:      w,
-		secret: secret,
-		nonce:  nonce,
+		sealer: Sealer{secret: secret, nonce: nonce},
 	}
 }
 
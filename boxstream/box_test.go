@@ -5,6 +5,7 @@
 package boxstream
 
 import (
+	"bytes"
 	"io"
 	"net"
 	"testing"
@@ -80,3 +81,67 @@ func TestBox(t *testing.T) {
 	}
 
 }
+
+func TestBoxTooLarge(t *testing.T) {
+	var secret [32]byte
+	var nonce [24]byte
+
+	var buf bytes.Buffer
+	bw := NewBoxer(&buf, &nonce, &secret)
+
+	err := bw.WriteMessage(make([]byte, MaxSegmentSize+1))
+	if err != ErrSegmentTooLarge {
+		t.Fatal("expected ErrSegmentTooLarge, got", err)
+	}
+
+	if buf.Len() != 0 {
+		t.Error("data was written")
+	}
+
+	if nonce != [24]byte{} {
+		t.Error("nonce was changed")
+	}
+}
+
+func TestBoxWipe(t *testing.T) {
+	var boxKey, unboxKey [32]byte
+	var boxNonce, unboxNonce [24]byte
+	for i := range boxKey {
+		boxKey[i] = byte(3 * i)
+	}
+	unboxKey = boxKey
+
+	var stream bytes.Buffer
+	bw := NewBoxer(&stream, &boxNonce, &boxKey)
+	br := NewUnboxer(&stream, &unboxNonce, &unboxKey)
+
+	if err := bw.WriteMessage([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := br.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	bw.Wipe()
+	bw.Wipe()
+	br.Wipe()
+	if boxKey != [32]byte{} || unboxKey != [32]byte{} {
+		t.Error("keys not wiped")
+	}
+	if boxNonce != [24]byte{} || unboxNonce != [24]byte{} {
+		t.Error("nonces not wiped")
+	}
+
+	if err := bw.WriteMessage([]byte("hello")); err != ErrWiped {
+		t.Error("expected ErrWiped from WriteMessage, got", err)
+	}
+	if err := bw.WriteGoodbye(); err != ErrWiped {
+		t.Error("expected ErrWiped from WriteGoodbye, got", err)
+	}
+	if _, err := br.ReadMessage(); err != ErrWiped {
+		t.Error("expected ErrWiped from ReadMessage, got", err)
+	}
+	if stream.Len() != 0 {
+		t.Error("wiped boxer wrote", stream.Len(), "bytes")
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The functions in this file encrypt and decrypt single boxstream frames
+// without doing any I/O, so the protocol can be used over message based
+// transports or from an event loop.
+//
+// A frame consists of a header box of HeaderLength bytes, sealed with the
+// frame nonce, followed by the body, sealed with the frame nonce + 1. The
+// header contains the body length and the MAC of the body box. The next frame
+// starts at the frame nonce + 2, see NextFrameNonce.
+
+// Header is the decrypted header of a boxstream frame.
+type Header struct {
+	// BodyLength is the number of bytes of the body that follows the header.
+	BodyLength int
+
+	// Goodbye is set if the frame ends the stream. It has no body.
+	Goodbye bool
+
+	bodyMAC [secretbox.Overhead]byte
+}
+
+// SealFrame appends the boxstream frame for msg to dst and returns the
+// resulting slice. It uses nonce and its successor but doesn't modify it.
+func SealFrame(dst, msg []byte, nonce *[24]byte, secret *[32]byte) ([]byte, error) {
+	if len(msg) > MaxSegmentSize {
+		return dst, ErrSegmentTooLarge
+	}
+
+	headerNonce := *nonce
+	bodyNonce := *nonce
+	increment(&bodyNonce)
+
+	// construct body box
+	bodyBox := secretbox.Seal(nil, msg, &bodyNonce, secret)
+	bodyMAC, body := bodyBox[:secretbox.Overhead], bodyBox[secretbox.Overhead:]
+
+	// construct header box
+	var header [2 + secretbox.Overhead]byte
+	binary.BigEndian.PutUint16(header[:2], uint16(len(msg)))
+	copy(header[2:], bodyMAC)
+
+	dst = secretbox.Seal(dst, header[:], &headerNonce, secret)
+	return append(dst, body...), nil
+}
+
+// SealGoodbye appends the 'goodbye' frame that ends a stream to dst. It uses
+// nonce but doesn't modify it.
+func SealGoodbye(dst []byte, nonce *[24]byte, secret *[32]byte) []byte {
+	return secretbox.Seal(dst, goodbye[:], nonce, secret)
+}
+
+// OpenHeader decrypts the header box at the start of a frame. box must be
+// HeaderLength bytes long. It uses nonce but doesn't modify it.
+func OpenHeader(box []byte, nonce *[24]byte, secret *[32]byte) (Header, error) {
+	var h Header
+	if len(box) != HeaderLength {
+		return h, ErrInvalidHeader
+	}
+
+	var headerBuf [2 + secretbox.Overhead]byte
+	header, ok := secretbox.Open(headerBuf[:0], box, nonce, secret)
+	if !ok {
+		return h, ErrInvalidHeader
+	}
+
+	// zero header indicates termination
+	if bytes.Equal(header, goodbye[:]) {
+		h.Goodbye = true
+		return h, nil
+	}
+
+	h.BodyLength = int(binary.BigEndian.Uint16(header[:2]))
+	if h.BodyLength > MaxSegmentSize {
+		return h, ErrSegmentTooLarge
+	}
+	copy(h.bodyMAC[:], header[2:])
+	return h, nil
+}
+
+// OpenBody decrypts the body of a frame, using the header returned by
+// OpenHeader, and appends the plaintext to dst. nonce is the same frame nonce
+// that was passed to OpenHeader, it isn't modified.
+func OpenBody(dst, body []byte, h Header, nonce *[24]byte, secret *[32]byte) ([]byte, error) {
+	if len(body) != h.BodyLength || h.Goodbye {
+		return dst, ErrInvalidBody
+	}
+
+	bodyBox := make([]byte, secretbox.Overhead+len(body))
+	copy(bodyBox, h.bodyMAC[:])
+	copy(bodyBox[secretbox.Overhead:], body)
+
+	return openBodyBox(dst, bodyBox, nonce, secret)
+}
+
+// openBodyBox opens a body that is already prefixed with the MAC from its header.
+func openBodyBox(dst, bodyBox []byte, nonce *[24]byte, secret *[32]byte) ([]byte, error) {
+	bodyNonce := *nonce
+	increment(&bodyNonce)
+
+	msg, ok := secretbox.Open(dst, bodyBox, &bodyNonce, secret)
+	if !ok {
+		return dst, ErrInvalidBody
+	}
+	return msg, nil
+}
+
+// NextFrameNonce advances nonce from one frame to the next.
+func NextFrameNonce(nonce *[24]byte) {
+	increment(nonce)
+	increment(nonce)
+}
+
+// Sealer seals consecutive frames of a stream and keeps track of the nonce.
+type Sealer struct {
+	secret *[32]byte
+	nonce  *[24]byte
+}
+
+// NewSealer returns a Sealer that starts at nonce. The nonce is advanced in place.
+func NewSealer(nonce *[24]byte, secret *[32]byte) *Sealer {
+	return &Sealer{secret: secret, nonce: nonce}
+}
+
+// Seal appends the next frame for msg to dst.
+func (s *Sealer) Seal(dst, msg []byte) ([]byte, error) {
+	out, err := SealFrame(dst, msg, s.nonce, s.secret)
+	if err != nil {
+		return out, err
+	}
+	NextFrameNonce(s.nonce)
+	return out, nil
+}
+
+// SealGoodbye appends the 'goodbye' frame to dst.
+func (s *Sealer) SealGoodbye(dst []byte) []byte {
+	return SealGoodbye(dst, s.nonce, s.secret)
+}
+
+// wipe overwrites the key and the nonce
+func (s *Sealer) wipe() {
+	*s.secret = [32]byte{}
+	*s.nonce = [24]byte{}
+}
+
+// Opener opens consecutive frames of a stream and keeps track of the nonce.
+// Each frame is opened with a call to OpenHeader, followed by OpenBody with
+// the BodyLength bytes that follow the header, even if that is zero. A
+// 'goodbye' header has no body.
+type Opener struct {
+	secret *[32]byte
+	nonce  *[24]byte
+
+	header  Header
+	pending bool // header opened, body missing
+}
+
+// NewOpener returns an Opener that starts at nonce. The nonce is advanced in place.
+func NewOpener(nonce *[24]byte, secret *[32]byte) *Opener {
+	return &Opener{secret: secret, nonce: nonce}
+}
+
+// OpenHeader opens the header of the next frame. It returns ErrOutOfOrder if
+// the body of the previous frame wasn't opened yet.
+func (o *Opener) OpenHeader(box []byte) (Header, error) {
+	if o.pending {
+		return Header{}, ErrOutOfOrder
+	}
+
+	h, err := OpenHeader(box, o.nonce, o.secret)
+	if err != nil || h.Goodbye {
+		return h, err
+	}
+
+	o.header = h
+	o.pending = true
+	return h, nil
+}
+
+// OpenBody opens the body belonging to the last header and appends the plaintext to dst.
+// It returns ErrOutOfOrder if there is no header waiting for its body.
+func (o *Opener) OpenBody(dst, body []byte) ([]byte, error) {
+	if !o.pending {
+		return dst, ErrOutOfOrder
+	}
+
+	msg, err := OpenBody(dst, body, o.header, o.nonce, o.secret)
+	if err != nil {
+		return msg, err
+	}
+	o.done()
+	return msg, nil
+}
+
+// openBodyBox is like OpenBody but takes a body that is already prefixed with
+// the MAC from its header, which saves the Unboxer a copy.
+func (o *Opener) openBodyBox(dst, bodyBox []byte) ([]byte, error) {
+	if !o.pending {
+		return dst, ErrOutOfOrder
+	}
+
+	copy(bodyBox, o.header.bodyMAC[:])
+	msg, err := openBodyBox(dst, bodyBox, o.nonce, o.secret)
+	if err != nil {
+		return msg, err
+	}
+	o.done()
+	return msg, nil
+}
+
+func (o *Opener) done() {
+	o.pending = false
+	NextFrameNonce(o.nonce)
+}
+
+// wipe overwrites the key, the nonce and the last header
+func (o *Opener) wipe() {
+	*o.secret = [32]byte{}
+	*o.nonce = [24]byte{}
+	o.header = Header{}
+	o.pending = false
+}
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCodecMatchesBoxer(t *testing.T) {
+	var secret [32]byte
+	var boxnonce, sealnonce [24]byte
+	for i := range secret {
+		secret[i] = byte(3 * i)
+	}
+	for i := range boxnonce {
+		boxnonce[i] = byte(5 * i)
+	}
+	copy(sealnonce[:], boxnonce[:])
+
+	msgs := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{7}, MaxSegmentSize)}
+
+	var stream bytes.Buffer
+	bw := NewBoxer(&stream, &boxnonce, &secret)
+	for _, msg := range msgs {
+		if err := bw.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.WriteGoodbye(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each datagram holds one frame
+	var datagrams [][]byte
+	sealer := NewSealer(&sealnonce, &secret)
+	for _, msg := range msgs {
+		frame, err := sealer.Seal(nil, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		datagrams = append(datagrams, frame)
+	}
+	datagrams = append(datagrams, sealer.SealGoodbye(nil))
+
+	if !bytes.Equal(stream.Bytes(), bytes.Join(datagrams, nil)) {
+		t.Fatal("Sealer output differs from Boxer")
+	}
+
+	var opennonce [24]byte
+	for i := range opennonce {
+		opennonce[i] = byte(5 * i)
+	}
+	opener := NewOpener(&opennonce, &secret)
+	for i, msg := range msgs {
+		d := datagrams[i]
+		h, err := opener.OpenHeader(d[:HeaderLength])
+		if err != nil {
+			t.Fatalf("header %d: %v", i, err)
+		}
+		if h.Goodbye || h.BodyLength != len(msg) {
+			t.Fatalf("header %d: wrong header %+v", i, h)
+		}
+
+		got, err := opener.OpenBody(nil, d[HeaderLength:])
+		if err != nil {
+			t.Fatalf("body %d: %v", i, err)
+		}
+		if !bytes.Equal(msg, got) {
+			t.Fatalf("body %d: wrong message", i)
+		}
+	}
+
+	h, err := opener.OpenHeader(datagrams[len(msgs)])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Goodbye {
+		t.Fatal("expected goodbye")
+	}
+
+	// the Unboxer reads what the Sealer produced
+	for i := range boxnonce {
+		boxnonce[i] = byte(5 * i)
+	}
+	br := NewUnboxer(bytes.NewReader(bytes.Join(datagrams, nil)), &boxnonce, &secret)
+	for i, msg := range msgs {
+		got, err := br.ReadMessage()
+		if err != nil {
+			t.Fatalf("unboxer %d: %v", i, err)
+		}
+		if !bytes.Equal(msg, got) {
+			t.Fatalf("unboxer %d: wrong message", i)
+		}
+	}
+	if _, err := br.ReadMessage(); err != io.EOF {
+		t.Fatal("expected EOF, got", err)
+	}
+}
+
+func TestCodecExplicitNonce(t *testing.T) {
+	var secret [32]byte
+	var nonce [24]byte
+	nonce[23] = 0xfe // carry over into the next byte
+
+	first, err := SealFrame(nil, []byte("first"), &nonce, &secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce[23] != 0xfe {
+		t.Fatal("SealFrame modified the nonce")
+	}
+
+	next := nonce
+	NextFrameNonce(&next)
+	second, err := SealFrame(nil, []byte("second"), &next, &secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// frames can be opened out of order if the nonce is known
+	h, err := OpenHeader(second[:HeaderLength], &next, &secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := OpenBody(nil, second[HeaderLength:], h, &next, &secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "second" {
+		t.Errorf("wrong message: %q", msg)
+	}
+
+	// the wrong nonce fails
+	if _, err := OpenHeader(first[:HeaderLength], &next, &secret); !errors.Is(err, ErrInvalidHeader) {
+		t.Error("expected ErrInvalidHeader, got", err)
+	}
+
+	h, err = OpenHeader(first[:HeaderLength], &nonce, &secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), first[HeaderLength:]...)
+	tampered[0] ^= 1
+	if _, err := OpenBody(nil, tampered, h, &nonce, &secret); !errors.Is(err, ErrInvalidBody) {
+		t.Error("expected ErrInvalidBody, got", err)
+	}
+
+	if _, err := SealFrame(nil, make([]byte, MaxSegmentSize+1), &nonce, &secret); err != ErrSegmentTooLarge {
+		t.Error("expected ErrSegmentTooLarge, got", err)
+	}
+}
+
+func TestOpenerOutOfOrder(t *testing.T) {
+	var secret [32]byte
+	var sealnonce, opennonce [24]byte
+
+	frame, err := NewSealer(&sealnonce, &secret).Seal(nil, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opener := NewOpener(&opennonce, &secret)
+	if _, err := opener.OpenBody(nil, frame[HeaderLength:]); err != ErrOutOfOrder {
+		t.Fatal("expected ErrOutOfOrder for a body without header, got", err)
+	}
+	if _, err := opener.OpenHeader(frame[:HeaderLength]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opener.OpenHeader(frame[:HeaderLength]); err != ErrOutOfOrder {
+		t.Fatal("expected ErrOutOfOrder for a second header, got", err)
+	}
+
+	// the misuse didn't advance the opener
+	msg, err := opener.OpenBody(nil, frame[HeaderLength:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello" {
+		t.Errorf("wrong message: %q", msg)
+	}
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSegmentTooLarge is returned if a message doesn't fit into a single boxstream packet.
+	ErrSegmentTooLarge = errors.New("boxstream: message exceeds maximum segment size")
+
+	// ErrInvalidHeader is returned if a header box can't be opened, because it
+	// was tampered with or the stream is out of sync.
+	ErrInvalidHeader = errors.New("boxstream: invalid header box")
+
+	// ErrInvalidBody is returned if a body box doesn't match the MAC from its header.
+	ErrInvalidBody = errors.New("boxstream: invalid body box")
+
+	// ErrOutOfOrder is returned by Opener if a header is opened while a body
+	// was expected, or a body without a header.
+	ErrOutOfOrder = errors.New("boxstream: header and body opened out of order")
+
+	// ErrWiped is returned by Boxer and Unboxer once their keys were wiped.
+	ErrWiped = errors.New("boxstream: keys were wiped")
+)
+
+// FrameError is returned by Unboxer if a frame can't be decrypted. It records
+// where in the stream the failure happened and wraps ErrInvalidHeader,
+// ErrInvalidBody or ErrSegmentTooLarge.
+type FrameError struct {
+	// Frame is the index of the failing frame, starting at 0.
+	Frame uint64
+
+	// Offset is the position of the frame's header in the stream, in bytes.
+	Offset int64
+
+	Err error
+}
+
+func (e FrameError) Error() string {
+	return fmt.Sprintf("%s (frame %d at offset %d)", e.Err, e.Frame, e.Offset)
+}
+
+// Unwrap returns the reason
+func (e FrameError) Unwrap() error { return e.Err }
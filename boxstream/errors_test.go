@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFrameError(t *testing.T) {
+	msgs := [][]byte{
+		[]byte("first"),
+		[]byte("second message"),
+		[]byte("third"),
+	}
+	// offset of the third frame
+	thirdOffset := int64(2*HeaderLength + len(msgs[0]) + len(msgs[1]))
+
+	type testcase struct {
+		flip int // byte to tamper with, relative to the start of the third frame
+		want error
+	}
+	tcs := map[string]testcase{
+		"header": {flip: 3, want: ErrInvalidHeader},
+		"body":   {flip: HeaderLength + 1, want: ErrInvalidBody},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			var secret [32]byte
+			var boxnonce, unboxnonce [24]byte
+
+			var stream bytes.Buffer
+			bw := NewBoxer(&stream, &boxnonce, &secret)
+			for _, msg := range msgs {
+				if err := bw.WriteMessage(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tampered := stream.Bytes()
+			tampered[thirdOffset+int64(tc.flip)] ^= 0xff
+
+			br := NewUnboxer(bytes.NewReader(tampered), &unboxnonce, &secret)
+			for i := 0; i < 2; i++ {
+				if _, err := br.ReadMessage(); err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+			}
+
+			_, err := br.ReadMessage()
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+
+			var fe FrameError
+			if !errors.As(err, &fe) {
+				t.Fatalf("not a FrameError: %T", err)
+			}
+			if fe.Frame != 2 {
+				t.Errorf("wrong frame index: %d", fe.Frame)
+			}
+			if fe.Offset != thirdOffset {
+				t.Errorf("wrong offset: %d (expected %d)", fe.Offset, thirdOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// MaxFragmentSize is the number of message bytes a MessageWriter puts into a
+	// single boxstream packet. The first byte of each packet body is used for the
+	// continuation marker.
+	MaxFragmentSize = MaxSegmentSize - 1
+
+	// DefaultMaxMessageSize is the message size limit of a MessageReader if none is given.
+	DefaultMaxMessageSize = 1024 * 1024
+)
+
+// continuation markers, the first byte of each fragment
+const (
+	fragmentFinal byte = 0
+	fragmentMore  byte = 1
+)
+
+// ErrMessageTooLarge is returned by MessageReader if a message exceeds the maximum message size.
+var ErrMessageTooLarge = errors.New("boxstream: message exceeds maximum message size")
+
+// ErrInvalidFragment is returned by MessageReader if a packet doesn't carry a valid continuation marker.
+var ErrInvalidFragment = errors.New("boxstream: invalid message fragment")
+
+// MessageWriter sends messages of arbitrary size as a sequence of boxstream
+// packets. Every packet starts with a marker that says whether more fragments
+// of the same message follow.
+type MessageWriter struct {
+	l   sync.Mutex
+	b   *Boxer
+	buf [MaxSegmentSize]byte
+}
+
+// NewMessageWriter returns a MessageWriter that writes fragments to b.
+func NewMessageWriter(b *Boxer) *MessageWriter {
+	return &MessageWriter{b: b}
+}
+
+// WriteMessage writes msg as one or more boxstream packets. The fragments of
+// concurrent calls are not interleaved.
+func (w *MessageWriter) WriteMessage(msg []byte) error {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	for {
+		n := len(msg)
+		marker := fragmentFinal
+		if n > MaxFragmentSize {
+			n = MaxFragmentSize
+			marker = fragmentMore
+		}
+
+		w.buf[0] = marker
+		copy(w.buf[1:], msg[:n])
+		if err := w.b.WriteMessage(w.buf[:1+n]); err != nil {
+			return err
+		}
+
+		msg = msg[n:]
+		if marker == fragmentFinal {
+			return nil
+		}
+	}
+}
+
+// MessageReader reassembles the messages sent by a MessageWriter.
+type MessageReader struct {
+	u   *Unboxer
+	max int
+}
+
+// NewMessageReader returns a MessageReader that reads fragments from u and
+// refuses messages larger than maxSize bytes. If maxSize <= 0,
+// DefaultMaxMessageSize is used.
+func NewMessageReader(u *Unboxer, maxSize int) *MessageReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	return &MessageReader{u: u, max: maxSize}
+}
+
+// ReadMessage reads the fragments of the next message and returns it in one
+// piece. If the stream ends between two messages it returns io.EOF, if it ends
+// in the middle of one io.ErrUnexpectedEOF.
+//
+// If the message exceeds the maximum size, its remaining fragments are
+// skipped and ErrMessageTooLarge is returned, so the next call starts at the
+// following message.
+func (r *MessageReader) ReadMessage() ([]byte, error) {
+	var (
+		msg      []byte
+		tooLarge bool
+	)
+
+	for first := true; ; first = false {
+		frag, err := r.u.ReadMessage()
+		if err != nil {
+			if err == io.EOF && !first {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if len(frag) == 0 {
+			return nil, ErrInvalidFragment
+		}
+
+		marker, body := frag[0], frag[1:]
+		if marker != fragmentFinal && marker != fragmentMore {
+			return nil, fmt.Errorf("%w: unknown marker %x", ErrInvalidFragment, marker)
+		}
+
+		if !tooLarge {
+			if len(msg)+len(body) > r.max {
+				tooLarge = true
+				msg = nil
+			} else {
+				msg = append(msg, body...)
+			}
+		}
+
+		if marker == fragmentFinal {
+			break
+		}
+	}
+
+	if tooLarge {
+		return nil, ErrMessageTooLarge
+	}
+
+	if msg == nil {
+		msg = []byte{}
+	}
+	return msg, nil
+}
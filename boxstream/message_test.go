@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func mkMessagePair(maxSize int) (*MessageWriter, *MessageReader, *Boxer) {
+	pr, pw := net.Pipe()
+
+	var secret [32]byte
+	var boxnonce, unboxnonce [24]byte
+	for i := range secret {
+		secret[i] = byte(3 * i)
+	}
+	for i := range boxnonce {
+		boxnonce[i] = byte(5 * i)
+	}
+	copy(unboxnonce[:], boxnonce[:])
+
+	bw := NewBoxer(pw, &boxnonce, &secret)
+	br := NewUnboxer(pr, &unboxnonce, &secret)
+
+	return NewMessageWriter(bw), NewMessageReader(br, maxSize), bw
+}
+
+func TestMessageFragmentation(t *testing.T) {
+	mw, mr, bw := mkMessagePair(0)
+
+	sizes := []int{0, 1, MaxFragmentSize, MaxFragmentSize + 1, 3*MaxFragmentSize + 17, DefaultMaxMessageSize}
+	msgs := make([][]byte, len(sizes))
+	for i, sz := range sizes {
+		msgs[i] = make([]byte, sz)
+		rand.Read(msgs[i])
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		for _, msg := range msgs {
+			if err := mw.WriteMessage(msg); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- bw.WriteGoodbye()
+	}()
+
+	for i, want := range msgs {
+		got, err := mr.ReadMessage()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Fatalf("message %d: wrong content (len %d, want %d)", i, len(got), len(want))
+		}
+	}
+
+	if _, err := mr.ReadMessage(); err != io.EOF {
+		t.Fatal("expected EOF, got", err)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	mw, mr, _ := mkMessagePair(2 * MaxFragmentSize)
+
+	errc := make(chan error, 1)
+	go func() {
+		if err := mw.WriteMessage(make([]byte, 5*MaxFragmentSize)); err != nil {
+			errc <- err
+			return
+		}
+		errc <- mw.WriteMessage([]byte("after"))
+	}()
+
+	_, err := mr.ReadMessage()
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatal("expected ErrMessageTooLarge, got", err)
+	}
+
+	// the reader skipped the rest of the large message
+	msg, err := mr.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "after" {
+		t.Errorf("wrong message after skipping: %q", msg)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageUnexpectedEOF(t *testing.T) {
+	_, mr, bw := mkMessagePair(0)
+
+	errc := make(chan error, 1)
+	go func() {
+		// a frame announcing more fragments, followed by the end of the stream
+		frag := append([]byte{fragmentMore}, "incomplete"...)
+		if err := bw.WriteMessage(frag); err != nil {
+			errc <- err
+			return
+		}
+		errc <- bw.WriteGoodbye()
+	}()
+
+	if _, err := mr.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected unexpected EOF, got", err)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats are the traffic counters of one direction of a boxstream.
+type Stats struct {
+	// Frames is the number of frames, not counting the 'goodbye'.
+	Frames uint64
+
+	// Bytes is the amount of plaintext that was transferred.
+	Bytes uint64
+
+	// WireBytes is the amount of encrypted data, including headers and the 'goodbye'.
+	WireBytes uint64
+
+	// LargestFrame is the plaintext size of the largest frame.
+	LargestFrame int
+
+	// LastFrame is the time the last frame was transferred, zero if there was none.
+	LastFrame time.Time
+}
+
+// SinceLastFrame returns the time that passed since the last frame or 0 if there was none.
+func (s Stats) SinceLastFrame() time.Duration {
+	if s.LastFrame.IsZero() {
+		return 0
+	}
+	return time.Since(s.LastFrame)
+}
+
+// counters are the Stats of a Boxer or Unboxer. They have their own lock, so
+// they can be read while the stream is blocked on I/O.
+type counters struct {
+	l sync.Mutex
+	s Stats
+}
+
+func (c *counters) frame(plain int) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.s.Frames++
+	c.s.Bytes += uint64(plain)
+	c.s.WireBytes += uint64(HeaderLength + plain)
+	if plain > c.s.LargestFrame {
+		c.s.LargestFrame = plain
+	}
+	c.s.LastFrame = time.Now()
+}
+
+func (c *counters) goodbye() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.s.WireBytes += HeaderLength
+}
+
+func (c *counters) get() Stats {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.s
+}
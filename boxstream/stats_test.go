@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package boxstream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	var secret [32]byte
+	var boxnonce, unboxnonce [24]byte
+
+	var stream bytes.Buffer
+	bw := NewBoxer(&stream, &boxnonce, &secret)
+	br := NewUnboxer(&stream, &unboxnonce, &secret)
+
+	if s := bw.Stats(); s != (Stats{}) || s.SinceLastFrame() != 0 {
+		t.Fatalf("fresh boxer has stats: %+v", s)
+	}
+
+	before := time.Now()
+	for _, n := range []int{10, 0, 300} {
+		if err := bw.WriteMessage(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.WriteGoodbye(); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		_, err := br.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, s := range map[string]Stats{"boxer": bw.Stats(), "unboxer": br.Stats()} {
+		if s.Frames != 3 {
+			t.Errorf("%s: wrong frame count %d", name, s.Frames)
+		}
+		if s.Bytes != 310 {
+			t.Errorf("%s: wrong byte count %d", name, s.Bytes)
+		}
+		if want := uint64(4*HeaderLength + 310); s.WireBytes != want {
+			t.Errorf("%s: wrong wire byte count %d (expected %d)", name, s.WireBytes, want)
+		}
+		if s.LargestFrame != 300 {
+			t.Errorf("%s: wrong largest frame %d", name, s.LargestFrame)
+		}
+		if s.LastFrame.Before(before) {
+			t.Errorf("%s: wrong last frame time %s", name, s.LastFrame)
+		}
+	}
+}
@@ -5,69 +5,98 @@
 package boxstream
 
 import (
-	"bytes"
-	"encoding/binary"
-	"errors"
 	"io"
+	"sync"
 
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
 // Unboxer decrypts everything that is read from it
 type Unboxer struct {
+	mu     sync.Mutex // held while reading, so Wipe waits for a read to end
+	wiped  bool
 	r      io.Reader
 	buf    [MaxSegmentSize + secretbox.Overhead]byte
-	secret *[32]byte
-	nonce  *[24]byte
+	opener Opener
+
+	frame  uint64 // index of the next frame
+	offset int64  // bytes read from r
+
+	counters counters
 }
 
 // ReadMessage reads the next message from the underlying stream. If the next
-// message was a 'goodbye', it returns io.EOF.
+// message was a 'goodbye', it returns io.EOF. If a frame can't be decrypted,
+// the returned error is a FrameError.
 func (u *Unboxer) ReadMessage() ([]byte, error) {
-	headerNonce := *u.nonce
-	increment(u.nonce)
-	bodyNonce := *u.nonce
-	increment(u.nonce)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.wiped {
+		return nil, ErrWiped
+	}
+
+	frameErr := FrameError{Frame: u.frame, Offset: u.offset}
+	u.frame++
 
 	// read and unbox header
 	headerBox := u.buf[:HeaderLength]
-	if _, err := io.ReadFull(u.r, headerBox); err != nil {
+	n, err := io.ReadFull(u.r, headerBox)
+	u.offset += int64(n)
+	if err != nil {
 		return nil, err
 	}
-	headerBuf := make([]byte, 0, 18)
-	header, ok := secretbox.Open(headerBuf, headerBox, &headerNonce, u.secret)
-	if !ok {
-		return nil, errors.New("invalid header box")
+	header, err := u.opener.OpenHeader(headerBox)
+	if err != nil {
+		frameErr.Err = err
+		return nil, frameErr
 	}
 
-	// zero header indicates termination
-	if bytes.Equal(header, goodbye[:]) {
+	if header.Goodbye {
+		u.counters.goodbye()
 		return nil, io.EOF
 	}
 
 	// read and unbox body
-	bodyLen := binary.BigEndian.Uint16(header[:2])
-	if bodyLen > MaxSegmentSize {
-		return nil, errors.New("message exceeds maximum segment size")
-	}
-	bodyBox := u.buf[:bodyLen+secretbox.Overhead]
-	if _, err := io.ReadFull(u.r, bodyBox[secretbox.Overhead:]); err != nil {
+	bodyBox := u.buf[:header.BodyLength+secretbox.Overhead]
+	n, err = io.ReadFull(u.r, bodyBox[secretbox.Overhead:])
+	u.offset += int64(n)
+	if err != nil {
 		return nil, err
 	}
-	// prepend with MAC from header
-	copy(bodyBox, header[2:])
-	msg, ok := secretbox.Open(nil, bodyBox, &bodyNonce, u.secret)
-	if !ok {
-		return nil, errors.New("invalid body box")
+	// the opener prepends the MAC from the header
+	msg, err := u.opener.openBodyBox(nil, bodyBox)
+	if err != nil {
+		frameErr.Err = err
+		return nil, frameErr
 	}
+	u.counters.frame(len(msg))
 	return msg, nil
 }
 
+// Wipe overwrites the key and nonce that were passed to NewUnboxer and the
+// buffered frame. Reads fail with ErrWiped afterwards. If a ReadMessage is in
+// progress, Wipe waits for it to return, so close the underlying reader first.
+func (u *Unboxer) Wipe() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.wiped {
+		return
+	}
+	u.wiped = true
+
+	u.opener.wipe()
+	u.buf = [len(u.buf)]byte{}
+}
+
+// Stats returns the counters of the messages read so far.
+func (u *Unboxer) Stats() Stats {
+	return u.counters.get()
+}
+
 // NewUnboxer wraps the passed Reader into an Unboxer.
 func NewUnboxer(r io.Reader, nonce *[24]byte, secret *[32]byte) *Unboxer {
 	return &Unboxer{
 		r:      r,
-		secret: secret,
-		nonce:  nonce,
+		opener: Opener{secret: secret, nonce: nonce},
 	}
 }
@@ -5,11 +5,9 @@
 package secretstream // import "github.com/ssbc/go-secretstream"
 
 import (
-	"fmt"
 	"net"
 	"time"
 
-	"github.com/ssbc/go-secretstream/boxstream"
 	"github.com/ssbc/go-secretstream/secrethandshake"
 
 	"github.com/ssbc/go-netwrap"
@@ -18,52 +16,76 @@ import (
 // Client can dial secret-handshake server endpoints
 type Client struct {
 	appKey []byte
-	kp     secrethandshake.EdKeyPair
+	id     secrethandshake.Identity
+	opts   options
+
+	locked *secrethandshake.LockedIdentity // owned copy of the private key, may be nil
+}
+
+// NewClient creates a new Client with the passed keyPair and appKey.
+// The private key is converted to curve25519 once, for all handshakes.
+func NewClient(kp secrethandshake.EdKeyPair, appKey []byte, opts ...Option) (*Client, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	id, locked, err := o.identity(kp)
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(id, appKey, o)
+	c.locked = locked
+	return c, nil
 }
 
-// NewClient creates a new Client with the passed keyPair and appKey
-func NewClient(kp secrethandshake.EdKeyPair, appKey []byte) (*Client, error) {
-	// TODO: consistancy check?!..
+// NewClientWithIdentity creates a new Client that authenticates with id, whose
+// private key may be held elsewhere, for instance in an ssh-agent.
+func NewClientWithIdentity(id secrethandshake.Identity, appKey []byte, opts ...Option) (*Client, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(id, appKey, o), nil
+}
+
+func newClient(id secrethandshake.Identity, appKey []byte, o options) *Client {
 	return &Client{
 		appKey: appKey,
-		kp:     kp,
-	}, nil
+		id:     id,
+		opts:   o,
+	}
+}
+
+// Close wipes the copy of the private key that NewClient keeps with
+// WithSecureMemory. Connections that were already established stay usable,
+// but new handshakes fail. Otherwise, Close does nothing.
+func (c *Client) Close() error {
+	if c.locked == nil {
+		return nil
+	}
+	return c.locked.Close()
 }
 
 // ConnWrapper returns a connection wrapper for the client.
 func (c *Client) ConnWrapper(pubKey []byte) netwrap.ConnWrapper {
 	return func(conn net.Conn) (net.Conn, error) {
-		state, err := secrethandshake.NewClientState(c.appKey, c.kp, pubKey)
-		if err != nil {
-			return nil, err
-		}
+		hs := handshake{
+			role:    secrethandshake.RoleClient,
+			appKey:  c.appKey,
+			local:   c.id.PublicKey(),
+			remote:  pubKey,
+			timeout: 30 * time.Second,
+			opts:    c.opts,
 
-		errc := make(chan error)
-		go func() {
-			errc <- secrethandshake.Client(state, conn)
-			close(errc)
-		}()
-
-		select {
-		case err := <-errc:
-			if err != nil {
-				return nil, err
-			}
-		case <-time.After(30 * time.Second):
-			return nil, fmt.Errorf("secretstream: handshake timeout")
+			newState: func(opts ...secrethandshake.StateOption) (*secrethandshake.State, error) {
+				return secrethandshake.NewClientStateWithIdentity(c.appKey, c.id, pubKey, opts...)
+			},
 		}
 
-		enKey, enNonce := state.GetBoxstreamEncKeys()
-		deKey, deNonce := state.GetBoxstreamDecKeys()
-
-		boxed := &Conn{
-			boxer:   boxstream.NewBoxer(conn, &enNonce, &enKey),
-			unboxer: boxstream.NewUnboxer(conn, &deNonce, &deKey),
-			conn:    conn,
-			local:   c.kp.Public[:],
-			remote:  state.Remote(),
+		boxed, err := hs.run(conn)
+		if err != nil {
+			return nil, err
 		}
-
 		return boxed, nil
 	}
 }
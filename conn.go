@@ -8,12 +8,17 @@ import (
 	"bytes"
 	"encoding/base64"
 	"errors"
+	"io"
 	"net"
 	"os"
+	"sync"
 	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/ssbc/go-secretstream/boxstream"
+	"github.com/ssbc/go-secretstream/internal/securemem"
+	"github.com/ssbc/go-secretstream/secrethandshake"
 
 	"github.com/ssbc/go-netwrap"
 )
@@ -37,21 +42,139 @@ func (a Addr) String() string {
 }
 
 // Conn is a boxstream wrapped net.Conn
+//
+// It is safe to read from and write to a Conn from different goroutines at
+// the same time. Concurrent Read and ReadMessage calls are serialized, as are
+// concurrent Write and WriteMessage calls, so the frames of one Write are
+// never interleaved with those of another. Close may be called from any
+// goroutine and more than once. It doesn't wait for an in-flight Write: if
+// one is blocked, Close closes the underlying connection without a goodbye,
+// which makes the Write fail. Once the connection is closed, its session keys
+// are wiped.
 type Conn struct {
+	// unix nanoseconds of the last frame from the remote, accessed
+	// atomically and first in the struct for 64-bit alignment on 32-bit
+	// platforms. Only kept with keepalive enabled.
+	lastArrival int64
+
 	conn net.Conn
+	keys *securemem.Buffer // holds a sessionKeys
 
-	boxer   *boxstream.Boxer
+	readMu  sync.Mutex
 	unboxer *boxstream.Unboxer
 	recvMsg []byte // last message read from unboxer
 
+	writeSem     chan struct{} // a mutex that Close can try without blocking
+	boxer        *boxstream.Boxer
+	wroteGoodbye bool // guarded by writeSem
+
+	// read-ahead, nil if disabled
+	frames       chan frame
+	readErr      error    // sticky error from the read-ahead goroutine
+	readDeadline deadline // applies to nextFrame instead of the network
+
+	pings   bool  // keepalive is enabled, empty frames are its pings
+	stalled int32 // 1 while the read-ahead buffer is full, accessed atomically
+
+	remoteEOF   chan struct{} // closed once the goodbye of the remote was read
+	eofOnce     sync.Once
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeErr    error
+	closeReason error // set before done is closed if the connection was aborted
+
+	hooksMu    sync.Mutex
+	closeHooks []func() // nil after close
+	closed     bool
+
 	// public keys
 	local, remote []byte
+
+	appKey      []byte
+	role        secrethandshake.Role // the part this side played in the handshake
+	handshake   time.Duration
+	established time.Time
+	sessionID   [32]byte
 }
 
-// Read implements io.Reader.
-func (conn *Conn) Read(p []byte) (int, error) {
-	if len(conn.recvMsg) == 0 {
+// sessionKeys are the keys and nonces of both directions of a Conn. They
+// contain no pointers, so they can be kept in locked memory.
+type sessionKeys struct {
+	enKey, deKey     [32]byte
+	enNonce, deNonce [24]byte
+}
+
+// newConn wraps conn into a Conn, using the session keys from the handshake result res.
+func newConn(conn net.Conn, res *secrethandshake.Result, role secrethandshake.Role, local, appKey []byte, handshake time.Duration, opts options) (*Conn, error) {
+	buf, err := securemem.Alloc(int(unsafe.Sizeof(sessionKeys{})), opts.secureMemory)
+	if err != nil {
+		return nil, err
+	}
+	keys := (*sessionKeys)(unsafe.Pointer(&buf.Bytes()[0]))
+	keys.enKey, keys.enNonce = res.BoxstreamEncKeys()
+	keys.deKey, keys.deNonce = res.BoxstreamDecKeys()
+
+	boxed := &Conn{
+		boxer:   boxstream.NewBoxer(conn, &keys.enNonce, &keys.enKey),
+		unboxer: boxstream.NewUnboxer(conn, &keys.deNonce, &keys.deKey),
+		conn:    conn,
+		keys:    buf,
+		local:   local,
+		remote:  res.Remote(),
+
+		appKey:      appKey,
+		role:        role,
+		handshake:   handshake,
+		established: time.Now(),
+		sessionID:   res.SessionID(),
+
+		writeSem:  make(chan struct{}, 1),
+		remoteEOF: make(chan struct{}),
+		done:      make(chan struct{}),
+
+		readDeadline: deadline{changed: make(chan struct{})},
+	}
+
+	readAhead := opts.readAhead
+	if opts.keepAlive.enabled() {
+		if readAhead == 0 {
+			readAhead = 1
+		}
+		boxed.pings = true
+		boxed.noteArrival()
+		go boxed.keepAlive(opts.keepAlive)
+	}
+
+	if readAhead > 0 {
+		boxed.frames = make(chan frame, readAhead)
+		go boxed.readAhead()
+	}
+
+	return boxed, nil
+}
+
+// readMessage returns the next decrypted message, either directly from the unboxer or from the read-ahead buffer.
+func (conn *Conn) readMessage() ([]byte, error) {
+	if conn.frames == nil {
 		msg, err := conn.unboxer.ReadMessage()
+		switch err {
+		case boxstream.ErrWiped:
+			err = conn.closedErr()
+		case io.EOF:
+			conn.noteEOF()
+		}
+		return msg, err
+	}
+	return conn.nextFrame()
+}
+
+// Read implements io.Reader. Empty frames carry no data and are skipped.
+func (conn *Conn) Read(p []byte) (int, error) {
+	conn.readMu.Lock()
+	defer conn.readMu.Unlock()
+
+	for len(conn.recvMsg) == 0 {
+		msg, err := conn.readMessage()
 		if err != nil {
 			return 0, err
 		}
@@ -64,39 +187,202 @@ func (conn *Conn) Read(p []byte) (int, error) {
 
 // Write implements io.Writer.
 func (conn *Conn) Write(p []byte) (int, error) {
+	conn.writeSem <- struct{}{}
+	defer func() { <-conn.writeSem }()
+	if conn.wroteGoodbye {
+		return 0, conn.writeErr(boxstream.ErrWiped)
+	}
+
 	for buf := bytes.NewBuffer(p); buf.Len() > 0; {
 		if err := conn.boxer.WriteMessage(buf.Next(boxstream.MaxSegmentSize)); err != nil {
-			return 0, err
+			return 0, conn.writeErr(err)
 		}
 	}
 	return len(p), nil
 }
 
-// Close closes the underlying net.Conn
+// ReadMessage returns the plaintext of the next boxstream frame, keeping the
+// message boundaries of the sending side's WriteMessage calls. If a previous
+// Read only consumed part of a frame, the remainder of that frame is returned.
+// If the remote sent a 'goodbye', it returns io.EOF.
+func (conn *Conn) ReadMessage() ([]byte, error) {
+	conn.readMu.Lock()
+	defer conn.readMu.Unlock()
+
+	if len(conn.recvMsg) > 0 {
+		msg := conn.recvMsg
+		conn.recvMsg = nil
+		return msg, nil
+	}
+	return conn.readMessage()
+}
+
+// WriteMessage sends msg as a single boxstream frame. If len(msg) exceeds
+// boxstream.MaxSegmentSize, it returns boxstream.ErrSegmentTooLarge. With
+// keepalive enabled, msg must not be empty, see WithKeepAlive.
+func (conn *Conn) WriteMessage(msg []byte) error {
+	if len(msg) == 0 && conn.pings {
+		return ErrEmptyMessage
+	}
+
+	conn.writeSem <- struct{}{}
+	defer func() { <-conn.writeSem }()
+	if conn.wroteGoodbye {
+		return conn.writeErr(boxstream.ErrWiped)
+	}
+
+	return conn.writeErr(conn.boxer.WriteMessage(msg))
+}
+
+// writeErr turns the error of the boxer after Close wiped its keys into the
+// error of writing to a closed net.Conn. Writes after the goodbye fail the same
+// way.
+func (conn *Conn) writeErr(err error) error {
+	if err != boxstream.ErrWiped {
+		return err
+	}
+	return &net.OpError{
+		Op:     "write",
+		Net:    conn.conn.LocalAddr().Network(),
+		Source: conn.conn.LocalAddr(),
+		Addr:   conn.conn.RemoteAddr(),
+		Err:    net.ErrClosed,
+	}
+}
+
+// goodbyeTimeout bounds how long Close waits for the remote to take the goodbye.
+const goodbyeTimeout = time.Second
+
+// Close sends a 'goodbye' to the remote and closes the underlying net.Conn.
+// If a Write is in progress, no goodbye can be sent between its frames, so
+// the underlying net.Conn is closed right away. Calls after the first one
+// return the same result without doing anything.
 func (conn *Conn) Close() error {
-	gerr := conn.boxer.WriteGoodbye()
-	if gerr != nil {
-		netErr := new(net.OpError)
-		if errors.As(gerr, &netErr) {
-			var sysCallErr = new(os.SyscallError)
-			if errors.As(netErr.Err, &sysCallErr) {
-				action := sysCallErr.Unwrap()
-				if action == syscall.ECONNRESET || action == syscall.EPIPE {
-					return nil
-				}
-			}
-			if netErr.Err.Error() == "use of closed network connection" {
-				return nil
+	conn.closeOnce.Do(func() {
+		close(conn.done)
+
+		var gerr error
+		select {
+		case conn.writeSem <- struct{}{}:
+			if !conn.wroteGoodbye {
+				// a remote that doesn't read must not keep Close waiting
+				conn.conn.SetWriteDeadline(time.Now().Add(goodbyeTimeout))
+				gerr = ignoreConnGone(conn.boxer.WriteGoodbye())
 			}
+			<-conn.writeSem
+		default:
+		}
+		cerr := ignoreConnGone(conn.conn.Close())
+		if gerr != nil {
+			conn.closeErr = gerr
+		} else {
+			conn.closeErr = cerr
 		}
-		return gerr
+
+		conn.wipe()
+		conn.runCloseHooks()
+	})
+	return conn.closeErr
+}
+
+// closeWrite sends a 'goodbye' to the remote without closing the connection,
+// so the remote reads io.EOF and the application can still read what the
+// remote sends until its own goodbye. It waits for a Write in progress, and
+// Write and WriteMessage fail afterwards. It does nothing if the connection
+// is closed or the goodbye was already sent.
+func (conn *Conn) closeWrite() error {
+	conn.writeSem <- struct{}{}
+	defer func() { <-conn.writeSem }()
+
+	if conn.wroteGoodbye || conn.closedErr() != nil {
+		return nil
 	}
+	conn.wroteGoodbye = true
+	return ignoreConnGone(conn.boxer.WriteGoodbye())
+}
 
-	if cerr := conn.conn.Close(); cerr != nil {
-		return cerr
+// noteEOF records that the remote said goodbye.
+func (conn *Conn) noteEOF() {
+	conn.eofOnce.Do(func() { close(conn.remoteEOF) })
+}
+
+// abort closes the underlying connection without sending a 'goodbye'. Reads
+// then return reason instead of a generic error.
+func (conn *Conn) abort(reason error) {
+	conn.closeOnce.Do(func() {
+		conn.closeReason = reason
+		close(conn.done)
+		conn.conn.Close()
+		conn.wipe()
+		conn.runCloseHooks()
+	})
+}
+
+// wipe destroys the session keys. It waits for a read in progress, so the
+// underlying connection must be closed before.
+func (conn *Conn) wipe() {
+	conn.boxer.Wipe()
+	conn.unboxer.Wipe()
+	conn.keys.Free()
+}
+
+// onClose registers f to be called once the connection is closed. If it
+// already is, f is called right away.
+func (conn *Conn) onClose(f func()) {
+	conn.hooksMu.Lock()
+	if conn.closed {
+		conn.hooksMu.Unlock()
+		f()
+		return
 	}
+	conn.closeHooks = append(conn.closeHooks, f)
+	conn.hooksMu.Unlock()
+}
 
-	return nil
+func (conn *Conn) runCloseHooks() {
+	conn.hooksMu.Lock()
+	hooks := conn.closeHooks
+	conn.closeHooks = nil
+	conn.closed = true
+	conn.hooksMu.Unlock()
+
+	for _, f := range hooks {
+		f()
+	}
+}
+
+// closedErr returns the reason the connection was closed with, or nil if it is still open.
+func (conn *Conn) closedErr() error {
+	select {
+	case <-conn.done:
+		if conn.closeReason != nil {
+			return conn.closeReason
+		}
+		return net.ErrClosed
+	default:
+		return nil
+	}
+}
+
+// ignoreConnGone filters errors that only say that the connection was already closed or reset.
+func ignoreConnGone(err error) error {
+	if err == nil {
+		return nil
+	}
+	netErr := new(net.OpError)
+	if errors.As(err, &netErr) {
+		var sysCallErr = new(os.SyscallError)
+		if errors.As(netErr.Err, &sysCallErr) {
+			action := sysCallErr.Unwrap()
+			if action == syscall.ECONNRESET || action == syscall.EPIPE {
+				return nil
+			}
+		}
+		if netErr.Err.Error() == "use of closed network connection" {
+			return nil
+		}
+	}
+	return err
 }
 
 // LocalAddr returns the local net.Addr with the local public key
@@ -109,14 +395,27 @@ func (conn *Conn) RemoteAddr() net.Addr {
 	return netwrap.WrapAddr(conn.conn.RemoteAddr(), Addr{conn.remote})
 }
 
-// SetDeadline passes the call to the underlying net.Conn
+// SetDeadline sets the read and write deadlines, see SetReadDeadline and
+// SetWriteDeadline.
 func (conn *Conn) SetDeadline(t time.Time) error {
-	return conn.conn.SetDeadline(t)
+	if err := conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return conn.SetWriteDeadline(t)
 }
 
-// SetReadDeadline passes the call to the underlying net.Conn
+// SetReadDeadline sets the deadline for Read and ReadMessage. Without
+// read-ahead, it passes the call to the underlying net.Conn. With read-ahead,
+// the background reader keeps reading from the network regardless of the
+// deadline, which only applies to waiting for decrypted frames. Either way, a
+// read that times out returns an error for which os.ErrDeadlineExceeded is
+// true with errors.Is, and reading works again after the deadline is moved.
 func (conn *Conn) SetReadDeadline(t time.Time) error {
-	return conn.conn.SetReadDeadline(t)
+	if conn.frames == nil {
+		return conn.conn.SetReadDeadline(t)
+	}
+	conn.readDeadline.set(t)
+	return nil
 }
 
 // SetWriteDeadline passes the call to the underlying net.Conn
@@ -0,0 +1,316 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-secretstream/boxstream"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessages(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"direct":    nil,
+		"readAhead": {WithReadAhead(2)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+
+			srv, cli := mkConnPair(t, nil, opts)
+
+			msgs := [][]byte{
+				[]byte("hello"),
+				{},
+				bytes.Repeat([]byte{1}, boxstream.MaxSegmentSize),
+				[]byte("world"),
+			}
+
+			errc := make(chan error, 1)
+			go func() {
+				for _, msg := range msgs {
+					if err := srv.WriteMessage(msg); err != nil {
+						errc <- err
+						return
+					}
+				}
+				errc <- srv.Close()
+			}()
+
+			for i, want := range msgs {
+				got, err := cli.ReadMessage()
+				r.NoError(err, "message %d", i)
+				r.Equal(len(want), len(got), "message %d", i)
+				r.True(bytes.Equal(want, got), "message %d", i)
+			}
+			r.NoError(<-errc)
+
+			_, err := cli.ReadMessage()
+			r.Equal(io.EOF, err)
+
+			r.NoError(cli.Close())
+		})
+	}
+}
+
+func TestMessagesMixedWithRead(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+
+	r.NoError(srv.WriteMessage([]byte("partial")))
+	r.NoError(srv.WriteMessage([]byte("next")))
+
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(cli, buf)
+	r.NoError(err)
+	r.Equal("part", string(buf))
+
+	// the remainder of the partially read frame comes first
+	msg, err := cli.ReadMessage()
+	r.NoError(err)
+	r.Equal("ial", string(msg))
+
+	msg, err = cli.ReadMessage()
+	r.NoError(err)
+	r.Equal("next", string(msg))
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestWriteMessageTooLarge(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+
+	err := cli.WriteMessage(make([]byte, boxstream.MaxSegmentSize+1))
+	r.Equal(boxstream.ErrSegmentTooLarge, err)
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+// run with -race to catch unsynchronized access to the boxer and unboxer state
+func TestConcurrentReadWrite(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+
+	const n = 100
+	msg := bytes.Repeat([]byte("ping"), 2000)
+
+	// both sides write and read at the same time
+	errc := make(chan error, 4)
+	for _, c := range []*Conn{srv, cli} {
+		c := c
+		go func() {
+			for i := 0; i < n; i++ {
+				if _, err := c.Write(msg); err != nil {
+					errc <- err
+					return
+				}
+			}
+			errc <- nil
+		}()
+		go func() {
+			buf := make([]byte, len(msg))
+			for i := 0; i < n; i++ {
+				if _, err := io.ReadFull(c, buf); err != nil {
+					errc <- err
+					return
+				}
+				if !bytes.Equal(buf, msg) {
+					errc <- fmt.Errorf("wrong data in message %d", i)
+					return
+				}
+			}
+			errc <- nil
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		r.NoError(<-errc)
+	}
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+
+	const (
+		writers = 8
+		writes  = 20
+		size    = 3*boxstream.MaxSegmentSize + 100
+	)
+
+	var wg sync.WaitGroup
+	errc := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w byte) {
+			defer wg.Done()
+			msg := bytes.Repeat([]byte{w}, size)
+			for i := 0; i < writes; i++ {
+				if _, err := srv.Write(msg); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}(byte(w))
+	}
+	go func() {
+		wg.Wait()
+		errc <- srv.Close()
+	}()
+
+	// the frames of one Write are never interleaved with those of another
+	buf := make([]byte, size)
+	for i := 0; i < writers*writes; i++ {
+		_, err := io.ReadFull(cli, buf)
+		r.NoError(err)
+		r.Equal(bytes.Repeat(buf[:1], size), buf, "write %d was interleaved", i)
+	}
+
+	_, err := cli.Read(buf)
+	r.Equal(io.EOF, err)
+	r.NoError(<-errc)
+	r.NoError(cli.Close())
+}
+
+func TestCloseDuringWrite(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+
+	const size = 2*boxstream.MaxSegmentSize + 1
+	msg := bytes.Repeat([]byte{23}, size)
+
+	// the remote sees either the goodbye after a complete Write or a broken
+	// connection, but never a goodbye in the middle of a Write
+	readErrc := make(chan error, 1)
+	reading := make(chan struct{})
+	go func() {
+		buf := make([]byte, size)
+		for i := 0; ; i++ {
+			if i == 10 {
+				close(reading)
+			}
+			if _, err := io.ReadFull(cli, buf); err != nil {
+				readErrc <- nil
+				return
+			}
+			if !bytes.Equal(msg, buf) {
+				readErrc <- fmt.Errorf("wrong data in message %d", i)
+				return
+			}
+		}
+	}()
+
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		for {
+			if _, err := srv.Write(msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	<-reading
+	r.NoError(srv.Close())
+	<-writeDone
+
+	r.NoError(<-readErrc)
+	cli.Close()
+}
+
+func TestCloseBlockedWrite(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+	defer cli.Close()
+
+	// cli never reads, so the writes fill the socket buffers and block
+	msg := make([]byte, boxstream.MaxSegmentSize)
+	writeErrc := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := srv.Write(msg); err != nil {
+				writeErrc <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- srv.Close() }()
+	select {
+	case err := <-closed:
+		r.NoError(err)
+	case <-time.After(goodbyeTimeout / 2):
+		t.Fatal("Close waited for the blocked Write")
+	}
+
+	select {
+	case err := <-writeErrc:
+		r.True(errors.Is(err, net.ErrClosed), "unexpected write error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Write still blocked after Close")
+	}
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"direct":    nil,
+		"readAhead": {WithReadAhead(2)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+
+			srv, cli := mkConnPair(t, nil, opts)
+
+			// a blocked reader is woken up by Close
+			readErrc := make(chan error, 1)
+			go func() {
+				_, err := cli.Read(make([]byte, 1))
+				readErrc <- err
+			}()
+
+			var wg sync.WaitGroup
+			errc := make(chan error, 10)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errc <- cli.Close()
+				}()
+			}
+			wg.Wait()
+			close(errc)
+
+			for err := range errc {
+				r.NoError(err)
+			}
+			r.NoError(cli.Close())
+
+			r.Error(<-readErrc)
+
+			r.NoError(srv.Close())
+		})
+	}
+}
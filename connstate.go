@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ConnectionState describes an established secretstream connection.
+type ConnectionState struct {
+	// Local and Remote are the long-term public keys of both parties.
+	Local, Remote ed25519.PublicKey
+
+	// AppKey is the network identifier the handshake was performed with.
+	AppKey []byte
+
+	// HandshakeDuration is the time it took to authenticate the connection.
+	HandshakeDuration time.Duration
+
+	// SessionID identifies the session. It is the same on both sides of the
+	// connection and unique to it, but doesn't reveal the session keys.
+	SessionID []byte
+
+	// NetConn is the underlying connection the boxstream is sent over.
+	// Writing to or reading from it directly breaks the stream.
+	NetConn net.Conn
+}
+
+// ConnectionState returns information about the connection, most importantly
+// the authenticated identity of the remote party.
+func (conn *Conn) ConnectionState() ConnectionState {
+	return ConnectionState{
+		Local:             append(ed25519.PublicKey(nil), conn.local...),
+		Remote:            append(ed25519.PublicKey(nil), conn.remote...),
+		AppKey:            append([]byte(nil), conn.appKey...),
+		HandshakeDuration: conn.handshake,
+		SessionID:         append([]byte(nil), conn.sessionID[:]...),
+		NetConn:           conn.conn,
+	}
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionState(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+
+	srvState := srv.ConnectionState()
+	cliState := cli.ConnectionState()
+
+	r.Equal(serverKeys.Public, srvState.Local)
+	r.Equal(clientKeys.Public, srvState.Remote)
+	r.Equal(clientKeys.Public, cliState.Local)
+	r.Equal(serverKeys.Public, cliState.Remote)
+
+	r.Equal(appKey, srvState.AppKey)
+	r.Equal(appKey, cliState.AppKey)
+
+	r.Len(srvState.SessionID, 32)
+	r.Equal(srvState.SessionID, cliState.SessionID)
+
+	r.True(srvState.HandshakeDuration > 0)
+	r.True(cliState.HandshakeDuration > 0)
+
+	r.Equal(srv.conn, srvState.NetConn)
+	r.Equal(cli.conn.RemoteAddr(), cliState.NetConn.RemoteAddr())
+
+	// the returned keys are copies
+	cliState.Remote[0]++
+	r.Equal(serverKeys.Public, cli.ConnectionState().Remote)
+
+	// a new connection gets a different session
+	srv2, cli2 := mkConnPair(t, nil, nil)
+	r.NotEqual(srvState.SessionID, srv2.ConnectionState().SessionID)
+
+	for _, c := range []*Conn{srv, cli, srv2, cli2} {
+		r.NoError(c.Close())
+	}
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package discovery finds secretstream servers on the local network.
+//
+// Like other SSB implementations, it periodically broadcasts the multiserver
+// address of the local server, net:host:port~shs:key, over UDP and listens for
+// the announcements of others.
+package discovery
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// DefaultPort is the UDP port SSB peers announce themselves on.
+const DefaultPort = 8008
+
+// DefaultInterval is the time between announcements if Config.Interval is 0.
+const DefaultInterval = time.Second
+
+// Config configures a Discovery. The zero value announces on and listens to
+// the default port on all interfaces.
+type Config struct {
+	// ListenAddr is the UDP address announcements are received on.
+	// It defaults to ":8008". Only one process per host can use it.
+	ListenAddr string
+
+	// BroadcastAddrs are the UDP addresses announcements are sent to.
+	// If empty, it is the limited broadcast address 255.255.255.255 on the DefaultPort.
+	BroadcastAddrs []*net.UDPAddr
+
+	// Interval is the time between announcements, DefaultInterval if 0.
+	Interval time.Duration
+}
+
+// maxSeen is the number of peers remembered to report each of them once. It
+// bounds the memory a flood of made-up announcements can take.
+const maxSeen = 1024
+
+// Discovery announces a peer and reports the peers that announce themselves.
+type Discovery struct {
+	self  Peer
+	conn  *net.UDPConn
+	peers chan Peer
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New starts announcing self and listening for announcements. If the IP of
+// self is unspecified, as for a server listening on all interfaces, peers
+// substitute the IP the announcement was sent from. A nil self only listens,
+// otherwise it needs an address and a key.
+func New(self *Peer, cfg Config) (*Discovery, error) {
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":8008"
+	}
+	if len(cfg.BroadcastAddrs) == 0 {
+		cfg.BroadcastAddrs = []*net.UDPAddr{{IP: net.IPv4bcast, Port: DefaultPort}}
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Interval < 0 {
+		return nil, errors.New("discovery: negative interval")
+	}
+	if self != nil && self.Addr == nil {
+		return nil, errors.New("discovery: no address to announce")
+	}
+	if self != nil && len(self.PubKey) != ed25519.PublicKeySize {
+		return nil, errors.New("discovery: invalid key to announce")
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", cfg.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Discovery{
+		conn:  conn,
+		peers: make(chan Peer),
+		done:  make(chan struct{}),
+	}
+
+	if self != nil {
+		d.self = *self
+	}
+
+	d.wg.Add(1)
+	go d.receive()
+
+	if self != nil {
+		d.wg.Add(1)
+		go d.announce(cfg.BroadcastAddrs, cfg.Interval)
+	}
+	return d, nil
+}
+
+// LocalAddr returns the address announcements are received on.
+func (d *Discovery) LocalAddr() net.Addr {
+	return d.conn.LocalAddr()
+}
+
+// Peers returns the peers that announced themselves. Each combination of
+// address and key is reported once, unless more than 1024 others were seen
+// since it last announced itself. Own announcements are ignored. The channel
+// is closed by Close.
+func (d *Discovery) Peers() <-chan Peer {
+	return d.peers
+}
+
+// Close stops announcing and listening.
+func (d *Discovery) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.done)
+		err = d.conn.Close()
+		d.wg.Wait()
+		close(d.peers)
+	})
+	return err
+}
+
+func (d *Discovery) announce(to []*net.UDPAddr, interval time.Duration) {
+	defer d.wg.Done()
+
+	msg := []byte(d.self.String())
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		for _, addr := range to {
+			// an unreachable address shouldn't stop the others
+			d.conn.WriteToUDP(msg, addr)
+		}
+
+		select {
+		case <-tick.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Discovery) receive() {
+	defer d.wg.Done()
+
+	seen := newSeenSet(maxSeen)
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			// the connection was closed
+			return
+		}
+
+		peers, err := ParseAnnouncement(string(buf[:n]))
+		if err != nil {
+			continue
+		}
+
+		for _, p := range peers {
+			if bytes.Equal(p.PubKey, d.self.PubKey) {
+				continue
+			}
+			if p.Addr.IP.IsUnspecified() {
+				p.Addr.IP = from.IP
+			}
+
+			if !seen.add(p.String()) {
+				continue
+			}
+
+			select {
+			case d.peers <- p:
+			case <-d.done:
+				return
+			}
+		}
+	}
+}
+
+// seenSet remembers the most recently seen peers, forgetting the least
+// recently seen one when it is full.
+type seenSet struct {
+	max   int
+	order *list.List // of string, most recently seen first
+	index map[string]*list.Element
+}
+
+func newSeenSet(max int) *seenSet {
+	return &seenSet{
+		max:   max,
+		order: list.New(),
+		index: make(map[string]*list.Element),
+	}
+}
+
+// add reports whether id is new and marks it as the most recently seen.
+func (s *seenSet) add(id string) bool {
+	if e, ok := s.index[id]; ok {
+		s.order.MoveToFront(e)
+		return false
+	}
+	s.index[id] = s.order.PushFront(id)
+	if s.order.Len() > s.max {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.index, oldest.Value.(string))
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package discovery
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiscovery(t *testing.T) {
+	listener, err := New(nil, Config{ListenAddr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	to := []*net.UDPAddr{listener.LocalAddr().(*net.UDPAddr)}
+
+	// a server listening on all interfaces
+	unspecified := &Peer{Addr: &net.TCPAddr{IP: net.IPv4zero, Port: 8008}, PubKey: testKey(1)}
+	a, err := New(unspecified, Config{ListenAddr: "127.0.0.1:0", BroadcastAddrs: to, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	b, err := New(&Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 8009}, PubKey: testKey(2)},
+		Config{ListenAddr: "127.0.0.1:0", BroadcastAddrs: to, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	found := make(map[string]Peer)
+	timeout := time.After(5 * time.Second)
+	for len(found) < 2 {
+		select {
+		case p := <-listener.Peers():
+			if _, ok := found[p.String()]; ok {
+				t.Fatal("peer reported twice:", p)
+			}
+			found[p.String()] = p
+		case <-timeout:
+			t.Fatal("timeout, found:", found)
+		}
+	}
+
+	if _, ok := found["net:127.0.0.1:8008~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="]; !ok {
+		t.Error("the unspecified IP was not replaced with the sender's:", found)
+	}
+	if _, ok := found["net:10.0.0.2:8009~shs:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="]; !ok {
+		t.Error("missing peer b:", found)
+	}
+
+	// repeated announcements are not reported again
+	select {
+	case p := <-listener.Peers():
+		t.Fatal("unexpected peer:", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-listener.Peers(); ok {
+		t.Fatal("expected the peers channel to be closed")
+	}
+}
+
+func TestDiscoveryIgnoresSelf(t *testing.T) {
+	self := &Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}, PubKey: testKey(3)}
+	d, err := New(self, Config{ListenAddr: "127.0.0.1:0", Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// announce to ourselves
+	conn, err := net.DialUDP("udp", nil, d.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	other := Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8009}, PubKey: testKey(4)}
+	for _, p := range []Peer{*self, other} {
+		if _, err := conn.Write([]byte(p.String())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case p := <-d.Peers():
+		if !bytes.Equal(p.PubKey, other.PubKey) {
+			t.Fatal("reported own announcement:", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestDiscoveryInvalidSelf(t *testing.T) {
+	for _, self := range []*Peer{
+		{PubKey: testKey(3)},
+		{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}, PubKey: testKey(3)[:16]},
+	} {
+		if d, err := New(self, Config{ListenAddr: "127.0.0.1:0"}); err == nil {
+			d.Close()
+			t.Fatalf("expected an error for %+v", self)
+		}
+	}
+}
+
+func TestSeenSet(t *testing.T) {
+	s := newSeenSet(2)
+	for _, c := range []struct {
+		id  string
+		new bool
+	}{
+		{"a", true},
+		{"b", true},
+		{"a", false},
+		{"c", true}, // evicts b, a was seen more recently
+		{"a", false},
+		{"b", true}, // evicts c
+		{"a", false},
+		{"c", true},
+	} {
+		if got := s.add(c.id); got != c.new {
+			t.Fatalf("add(%q) = %v, want %v", c.id, got, c.new)
+		}
+	}
+	if len(s.index) != 2 || s.order.Len() != 2 {
+		t.Fatal("set grew beyond its size:", len(s.index), s.order.Len())
+	}
+}
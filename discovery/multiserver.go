@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package discovery
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Peer is a secretstream server that can be dialed over TCP.
+type Peer struct {
+	Addr   *net.TCPAddr
+	PubKey ed25519.PublicKey
+}
+
+// ShsAddr returns the address of p wrapped with its key, as returned by the
+// Addr method of listeners created with secretstream.Server.ListenerWrapper.
+func (p Peer) ShsAddr() net.Addr {
+	return netwrap.WrapAddr(p.Addr, secretstream.Addr{PubKey: p.PubKey})
+}
+
+// String returns p as a multiserver address, net:host:port~shs:key.
+func (p Peer) String() string {
+	host := net.JoinHostPort(p.Addr.IP.String(), strconv.Itoa(p.Addr.Port))
+	return "net:" + host + "~shs:" + base64.StdEncoding.EncodeToString(p.PubKey)
+}
+
+// ErrNoPeers is returned by ParseAnnouncement if it didn't contain a usable address.
+var ErrNoPeers = errors.New("discovery: no net~shs address in announcement")
+
+// ParseAnnouncement returns the peers in an announcement, which is a list of
+// multiserver addresses separated by ';'. Addresses that don't consist of a
+// net and an shs part are skipped, because they can't be dialed by this
+// package. If no address is left, it returns ErrNoPeers.
+func ParseAnnouncement(msg string) ([]Peer, error) {
+	var peers []Peer
+	for _, addr := range strings.Split(msg, ";") {
+		p, err := parseAddress(addr)
+		if err != nil {
+			continue
+		}
+		peers = append(peers, p)
+	}
+	if len(peers) == 0 {
+		return nil, ErrNoPeers
+	}
+	return peers, nil
+}
+
+// parseAddress parses a single net:host:port~shs:key address
+func parseAddress(addr string) (Peer, error) {
+	parts := strings.Split(addr, "~")
+	if len(parts) != 2 {
+		return Peer{}, fmt.Errorf("discovery: expected two transports in %q", addr)
+	}
+
+	netPart := strings.TrimPrefix(parts[0], "net:")
+	shsPart := strings.TrimPrefix(parts[1], "shs:")
+	if netPart == parts[0] || shsPart == parts[1] {
+		return Peer{}, fmt.Errorf("discovery: not a net~shs address: %q", addr)
+	}
+
+	host, portStr, err := net.SplitHostPort(netPart)
+	if err != nil {
+		return Peer{}, fmt.Errorf("discovery: invalid host in %q: %w", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return Peer{}, fmt.Errorf("discovery: invalid IP in %q", addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return Peer{}, fmt.Errorf("discovery: invalid port in %q", addr)
+	}
+
+	// the shs part may be followed by further options, like a seed
+	key, err := base64.StdEncoding.DecodeString(strings.SplitN(shsPart, ":", 2)[0])
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return Peer{}, fmt.Errorf("discovery: invalid key in %q", addr)
+	}
+
+	return Peer{
+		Addr:   &net.TCPAddr{IP: ip, Port: port},
+		PubKey: ed25519.PublicKey(key),
+	}, nil
+}
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package discovery
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestPeerString(t *testing.T) {
+	p := Peer{Addr: &net.TCPAddr{IP: net.IPv4(192, 168, 1, 5), Port: 8008}, PubKey: testKey(1)}
+	const want = "net:192.168.1.5:8008~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	if got := p.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if got := p.ShsAddr().String(); got != "192.168.1.5:8008|@AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=.ed25519" {
+		t.Fatal("unexpected shs address:", got)
+	}
+}
+
+func TestParseAnnouncement(t *testing.T) {
+	msg := "net:192.168.1.5:8008~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=;" +
+		"ws://192.168.1.5:8989~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=;" +
+		"net:[fe80::1]:8009~shs:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=:seed"
+
+	peers, err := ParseAnnouncement(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %v", peers)
+	}
+
+	if !peers[0].Addr.IP.Equal(net.IPv4(192, 168, 1, 5)) || peers[0].Addr.Port != 8008 || !bytes.Equal(peers[0].PubKey, testKey(1)) {
+		t.Error("wrong first peer:", peers[0])
+	}
+	if !peers[1].Addr.IP.Equal(net.ParseIP("fe80::1")) || peers[1].Addr.Port != 8009 || !bytes.Equal(peers[1].PubKey, testKey(2)) {
+		t.Error("wrong second peer:", peers[1])
+	}
+
+	// round trip
+	again, err := ParseAnnouncement(peers[1].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].String() != peers[1].String() {
+		t.Error("round trip changed the peer:", again[0])
+	}
+}
+
+func TestParseAnnouncementInvalid(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"net:192.168.1.5:8008",
+		"net:192.168.1.5~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+		"net:192.168.1.5:0~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+		"net:example.com:8008~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+		"net:192.168.1.5:8008~shs:AQEB",
+		"net:192.168.1.5:8008~noauth",
+	} {
+		if _, err := ParseAnnouncement(msg); err != ErrNoPeers {
+			t.Errorf("%q: expected ErrNoPeers, got %v", msg, err)
+		}
+	}
+}
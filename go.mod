@@ -2,7 +2,7 @@
 //
 // SPDX-License-Identifier: MIT
 
-go 1.13
+go 1.18
 
 module github.com/ssbc/go-secretstream
 
@@ -13,3 +13,13 @@ require (
 	go.mindeco.de v1.12.0
 	golang.org/x/crypto v0.17.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-logfmt/logfmt v0.4.0 // indirect
+	github.com/kr/logfmt v0.0.0-20140226030751-b84e30acd515 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
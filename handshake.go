@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+)
+
+// errHandshakeTimeout is returned if the remote didn't complete the handshake in time
+var errHandshakeTimeout = errors.New("secretstream: handshake timeout")
+
+// handshake is one side of the handshake of a connection
+type handshake struct {
+	role    secrethandshake.Role
+	appKey  []byte
+	local   []byte // long-term public key
+	remote  []byte // expected long-term key of the remote, nil on the server
+	timeout time.Duration
+	opts    options
+
+	newState func(...secrethandshake.StateOption) (*secrethandshake.State, error)
+}
+
+// run shakes hands over conn and wraps it into a Conn. If the remote takes
+// longer than the timeout, conn is closed.
+func (h handshake) run(conn net.Conn) (*Conn, error) {
+	start := time.Now()
+
+	stateOpts := h.opts.stateOptions(conn)
+	audit := h.opts.newAuditRecorder(conn, h.role, h.appKey, h.remote, start)
+	if audit != nil {
+		stateOpts = append(stateOpts, secrethandshake.WithHooks(audit))
+	}
+
+	state, err := h.newState(stateOpts...)
+	if err != nil {
+		audit.finish(err)
+		return nil, err
+	}
+
+	shake := secrethandshake.Client
+	if h.role == secrethandshake.RoleServer {
+		shake = secrethandshake.Server
+	}
+
+	var res *secrethandshake.Result
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		res, err = shake(state, conn)
+		errc <- err
+	}()
+
+	select {
+	case err = <-errc:
+	case <-time.After(h.timeout):
+		// unblock the handshake and wait for it to report where it stopped
+		conn.Close()
+		<-errc
+		// it may have finished just as the timer fired
+		if res != nil {
+			res.Wipe()
+		}
+		err = errHandshakeTimeout
+	}
+	if err != nil {
+		audit.finish(err)
+		return nil, err
+	}
+
+	boxed, err := newConn(conn, res, h.role, h.local, h.appKey, time.Since(start), h.opts)
+	res.Wipe()
+	if err != nil {
+		conn.Close()
+		audit.finish(err)
+		return nil, err
+	}
+
+	for _, t := range h.opts.trackers {
+		if err := t.track(boxed); err != nil {
+			boxed.Close()
+			audit.finish(err)
+			return nil, err
+		}
+	}
+
+	if err := audit.finish(nil); err != nil {
+		boxed.Close()
+		return nil, err
+	}
+
+	return boxed, nil
+}
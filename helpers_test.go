@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/stretchr/testify/require"
+)
+
+// mkListener returns a listener on localhost for a Server with the passed options.
+func mkListener(t *testing.T, srvOpts ...Option) net.Listener {
+	s, err := NewServer(*serverKeys, appKey, srvOpts...)
+	require.NoError(t, err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	require.NoError(t, err)
+	return l
+}
+
+// dial connects a client with the passed options to l.
+func dial(t *testing.T, l net.Listener, cliOpts ...Option) (net.Conn, error) {
+	c, err := NewClient(*clientKeys, appKey, cliOpts...)
+	require.NoError(t, err)
+
+	return netwrap.Dial(netwrap.GetAddr(l.Addr(), "tcp"), c.ConnWrapper(serverKeys.Public))
+}
+
+// mkConnPair returns a connected pair of secretstream connections over TCP on localhost.
+// The server and client are created with the passed options.
+func mkConnPair(t *testing.T, srvOpts, cliOpts []Option) (srv, cli *Conn) {
+	r := require.New(t)
+
+	l := mkListener(t, srvOpts...)
+	defer l.Close()
+
+	type accepted struct {
+		conn net.Conn
+		err  error
+	}
+	acceptc := make(chan accepted, 1)
+	go func() {
+		c, err := l.Accept()
+		acceptc <- accepted{c, err}
+	}()
+
+	client, err := dial(t, l, cliOpts...)
+	r.NoError(err)
+
+	a := <-acceptc
+	r.NoError(a.err)
+
+	return a.conn.(*Conn), client.(*Conn)
+}
+
+// eventually fails the test if cond doesn't become true within a second.
+// Unlike require.Eventually of testify v1.4.0, it calls cond from the test's
+// goroutine. testify starts cond in a new goroutine on every tick and closes
+// the channel they report to on timeout, so a slow cond panics with a send on
+// a closed channel.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// acceptLoop accepts connections from l in the background and passes them to
+// f, skipping failed handshakes, until l is closed.
+func acceptLoop(l net.Listener, f func(net.Conn)) {
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				// netwrap wraps errors with github.com/pkg/errors, which doesn't support errors.Is
+				for {
+					c, ok := err.(interface{ Cause() error })
+					if !ok {
+						break
+					}
+					err = c.Cause()
+				}
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			f(c)
+		}
+	}()
+}
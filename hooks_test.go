@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+type countingHooks struct {
+	secrethandshake.NopHooks
+
+	mu        sync.Mutex
+	addrs     []net.Addr
+	started   []secrethandshake.Role
+	sent      int
+	succeeded []ed25519.PublicKey
+}
+
+func (h *countingHooks) newHooks(remote net.Addr) secrethandshake.Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.addrs = append(h.addrs, remote)
+	return h
+}
+
+func (h *countingHooks) HandshakeStarted(r secrethandshake.Role) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = append(h.started, r)
+}
+
+func (h *countingHooks) MessageSent(secrethandshake.Stage, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sent++
+}
+
+func (h *countingHooks) HandshakeSucceeded(remote ed25519.PublicKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.succeeded = append(h.succeeded, remote)
+}
+
+func TestHandshakeHooks(t *testing.T) {
+	r := require.New(t)
+
+	var srvHooks, cliHooks countingHooks
+	srv, cli := mkConnPair(t,
+		[]Option{WithHandshakeHooks(srvHooks.newHooks)},
+		[]Option{WithHandshakeHooks(cliHooks.newHooks)},
+	)
+
+	r.Equal([]net.Addr{cli.conn.LocalAddr()}, srvHooks.addrs)
+	r.Equal([]secrethandshake.Role{secrethandshake.RoleServer}, srvHooks.started)
+	r.Equal(2, srvHooks.sent)
+	r.Equal([]ed25519.PublicKey{clientKeys.Public}, srvHooks.succeeded)
+
+	r.Equal([]net.Addr{srv.conn.LocalAddr()}, cliHooks.addrs)
+	r.Equal([]secrethandshake.Role{secrethandshake.RoleClient}, cliHooks.started)
+	r.Equal(2, cliHooks.sent)
+	r.Equal([]ed25519.PublicKey{serverKeys.Public}, cliHooks.succeeded)
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
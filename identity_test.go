@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream/secrethandshake/sshagent"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentIdentity(t *testing.T) {
+	r := require.New(t)
+
+	keyring := sshagent.NewKeyring()
+	r.NoError(keyring.Add(agent.AddedKey{PrivateKey: serverKeys.Secret}))
+	r.NoError(keyring.Add(agent.AddedKey{PrivateKey: clientKeys.Secret}))
+
+	srvID, err := sshagent.NewIdentity(keyring, serverKeys.Public)
+	r.NoError(err)
+	cliID, err := sshagent.NewIdentity(keyring, clientKeys.Public)
+	r.NoError(err)
+
+	s, err := NewServerWithIdentity(srvID, appKey)
+	r.NoError(err)
+	r.Equal(Addr{serverKeys.Public}, s.Addr())
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+	defer l.Close()
+	acceptLoop(l, func(c net.Conn) {
+		c.Write([]byte("hi"))
+		c.Close()
+	})
+
+	c, err := NewClientWithIdentity(cliID, appKey)
+	r.NoError(err)
+	conn, err := netwrap.Dial(netwrap.GetAddr(l.Addr(), "tcp"), c.ConnWrapper(serverKeys.Public))
+	r.NoError(err)
+	defer conn.Close()
+
+	r.Equal(clientKeys.Public, conn.(*Conn).ConnectionState().Local)
+
+	buf, err := ioutil.ReadAll(conn)
+	r.NoError(err)
+	r.Equal("hi", string(buf))
+}
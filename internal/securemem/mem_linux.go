@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package securemem
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const supported = true
+
+// madvDontDump is MADV_DONTDUMP, which the syscall package doesn't define
+const madvDontDump = 0x10
+
+// lockedPage maps a page of anonymous memory and locks it.
+func lockedPage(size int) ([]byte, error) {
+	page, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, fmt.Errorf("securemem: failed to map memory: %w", err)
+	}
+	if err := syscall.Mlock(page); err != nil {
+		syscall.Munmap(page)
+		return nil, fmt.Errorf("securemem: failed to lock memory (check RLIMIT_MEMLOCK): %w", err)
+	}
+
+	// keep secrets out of core dumps, older kernels don't support this
+	syscall.Madvise(page, madvDontDump)
+	return page, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package securemem
+
+const supported = false
+
+func lockedPage(size int) ([]byte, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package securemem keeps secrets in buffers that are wiped when they are
+// freed. Locked buffers are also excluded from swap and core dumps, which is
+// only supported on Linux.
+//
+// Locked buffers are carved out of whole pages, which are never returned to
+// the system. Freed slots are wiped and reused, so the amount of locked memory
+// only grows with the number of secrets held at the same time.
+package securemem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrUnsupported is returned by Alloc if locked memory isn't supported on this platform.
+var ErrUnsupported = errors.New("securemem: locked memory is not supported on this platform")
+
+// Buffer holds a secret. It is not safe for concurrent use.
+type Buffer struct {
+	b      []byte
+	locked bool
+}
+
+// Alloc returns a zeroed buffer of n bytes, in locked memory if locked is set.
+func Alloc(n int, locked bool) (*Buffer, error) {
+	if !locked {
+		return &Buffer{b: make([]byte, n)}, nil
+	}
+
+	b, err := slots.get(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Buffer{b: b, locked: true}, nil
+}
+
+// Bytes returns the contents of the buffer, or nil once it was freed.
+func (b *Buffer) Bytes() []byte {
+	return b.b
+}
+
+// Free wipes the buffer. It must not be used afterwards. Calls after the first one do nothing.
+func (b *Buffer) Free() {
+	if b.b == nil {
+		return
+	}
+	Wipe(b.b)
+	if b.locked {
+		slots.put(b.b)
+	}
+	b.b = nil
+}
+
+// Wipe overwrites b with zeros.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Supported returns whether locked memory is available on this platform. The
+// process may still lack the permission or the limit to lock more memory.
+func Supported() bool {
+	return supported
+}
+
+// minSlot is the size of the smallest slot. Larger ones double in size up to a page.
+const minSlot = 32
+
+var slots = pool{free: make(map[int][][]byte)}
+
+// pool hands out slots of locked pages
+type pool struct {
+	mu    sync.Mutex
+	free  map[int][][]byte // by slot size
+	inUse int
+}
+
+func slotSize(n int) int {
+	size := minSlot
+	for size < n {
+		size *= 2
+	}
+	return size
+}
+
+func (p *pool) get(n int) ([]byte, error) {
+	pageSize := os.Getpagesize()
+	size := slotSize(n)
+	if size > pageSize {
+		return nil, fmt.Errorf("securemem: %d bytes don't fit into a page", n)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	free := p.free[size]
+	if len(free) == 0 {
+		page, err := lockedPage(pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for off := 0; off < len(page); off += size {
+			free = append(free, page[off:off+size:off+size])
+		}
+	}
+
+	b := free[len(free)-1]
+	p.free[size] = free[:len(free)-1]
+	p.inUse++
+	return b[:n], nil
+}
+
+// put returns a wiped slot to the pool
+func (p *pool) put(b []byte) {
+	b = b[:cap(b)]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.free[cap(b)] = append(p.free[cap(b)], b)
+	p.inUse--
+}
+
+// InUse returns the number of locked buffers that were allocated and not freed yet.
+func InUse() int {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	return slots.inUse
+}
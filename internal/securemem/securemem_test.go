@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package securemem
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAlloc(t *testing.T) {
+	for _, locked := range []bool{false, true} {
+		if locked && !Supported() {
+			t.Log("skipping locked memory")
+			continue
+		}
+
+		buf, err := Alloc(40, locked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		if len(b) != 40 || !bytes.Equal(b, make([]byte, 40)) {
+			t.Fatalf("not a zeroed buffer of 40 bytes: %x", b)
+		}
+		copy(b, "secret")
+
+		buf.Free()
+		buf.Free()
+		if buf.Bytes() != nil {
+			t.Error("buffer still usable after Free")
+		}
+		if !bytes.Equal(b, make([]byte, 40)) {
+			t.Errorf("buffer not wiped: %x", b)
+		}
+	}
+}
+
+func TestAllocLockedReuse(t *testing.T) {
+	if !Supported() {
+		t.Skip("locked memory not supported")
+	}
+
+	// fill more than a page of 64 byte slots
+	var bufs []*Buffer
+	seen := make(map[*byte]bool)
+	for i := 0; i < 100; i++ {
+		buf, err := Alloc(64, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &buf.Bytes()[0]
+		if seen[p] {
+			t.Fatal("slot handed out twice")
+		}
+		seen[p] = true
+		bufs = append(bufs, buf)
+	}
+
+	// freed slots are wiped and handed out again
+	last := bufs[len(bufs)-1]
+	p := &last.Bytes()[0]
+	copy(last.Bytes(), "secret")
+	last.Free()
+
+	buf, err := Alloc(50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &buf.Bytes()[0] != p {
+		t.Error("freed slot not reused")
+	}
+	if !bytes.Equal(buf.Bytes(), make([]byte, 50)) {
+		t.Errorf("reused slot not wiped: %x", buf.Bytes())
+	}
+
+	for _, b := range bufs {
+		b.Free()
+	}
+	buf.Free()
+
+	if _, err := Alloc(1<<20, true); err == nil {
+		t.Error("expected error for more than a page")
+	}
+}
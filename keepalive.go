@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrEmptyMessage is returned by WriteMessage on a connection with keepalive
+// enabled, because empty frames are its pings.
+var ErrEmptyMessage = errors.New("secretstream: empty messages can't be sent with keepalive enabled")
+
+type idleTimeoutError struct{}
+
+func (idleTimeoutError) Error() string   { return "secretstream: nothing received within idle timeout" }
+func (idleTimeoutError) Timeout() bool   { return true }
+func (idleTimeoutError) Temporary() bool { return false }
+
+// ErrIdleTimeout is returned by Read and ReadMessage after the connection was
+// closed because nothing arrived within the idle timeout. It implements
+// net.Error and reports a timeout.
+var ErrIdleTimeout error = idleTimeoutError{}
+
+type keepAlive struct {
+	interval, idle time.Duration
+}
+
+func (ka keepAlive) enabled() bool { return ka.interval > 0 || ka.idle > 0 }
+
+// WithKeepAlive makes connections send an empty boxstream frame every
+// interval, so the remote can tell that it's still there, and close the
+// connection if no frame arrived within idleTimeout. Either of them can be 0
+// to only use the other.
+//
+// Pings are empty frames, so with keepalive enabled, empty frames are never
+// returned by ReadMessage and WriteMessage refuses to send them with
+// ErrEmptyMessage. A remote without keepalive gets the pings as empty messages
+// from ReadMessage, so both sides should agree on whether to use it. Read
+// always skips empty frames.
+//
+// The idle timeout only covers the remote: while the application doesn't
+// read and the read-ahead buffer is full, it is suspended.
+//
+// Keepalive needs a background reader, so it implies WithReadAhead(1) if no
+// read-ahead depth was set.
+func WithKeepAlive(interval, idleTimeout time.Duration) Option {
+	return func(o *options) error {
+		if interval < 0 || idleTimeout < 0 {
+			return fmt.Errorf("keepalive durations must not be negative")
+		}
+		if interval > 0 && idleTimeout > 0 && idleTimeout <= interval {
+			return fmt.Errorf("idle timeout (%s) must be longer than the keepalive interval (%s)", idleTimeout, interval)
+		}
+		o.keepAlive = keepAlive{interval: interval, idle: idleTimeout}
+		return nil
+	}
+}
+
+// keepAlive sends pings and watches for received frames until the connection is closed.
+func (conn *Conn) keepAlive(ka keepAlive) {
+	var (
+		tick <-chan time.Time
+		idle <-chan time.Time
+	)
+
+	if ka.interval > 0 {
+		ticker := time.NewTicker(ka.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var idleTimer *time.Timer
+	if ka.idle > 0 {
+		idleTimer = time.NewTimer(ka.idle)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	for {
+		select {
+		case <-conn.done:
+			return
+
+		case <-tick:
+			if err := conn.writePing(); err != nil {
+				return
+			}
+
+		case <-idle:
+			if left := conn.idleLeft(ka.idle, time.Now()); left > 0 {
+				idleTimer.Reset(left)
+				continue
+			}
+			conn.abort(ErrIdleTimeout)
+			return
+		}
+	}
+}
+
+// idleLeft returns how much of the idle timeout is left at now. The timeout
+// restarts with every frame from the remote and is suspended while frames
+// wait for the application.
+func (conn *Conn) idleLeft(idle time.Duration, now time.Time) time.Duration {
+	if atomic.LoadInt32(&conn.stalled) == 1 {
+		return idle
+	}
+	last := time.Unix(0, atomic.LoadInt64(&conn.lastArrival))
+	return idle - now.Sub(last)
+}
+
+// writePing sends an empty frame, unless the goodbye was sent.
+func (conn *Conn) writePing() error {
+	conn.writeSem <- struct{}{}
+	defer func() { <-conn.writeSem }()
+	if conn.wroteGoodbye {
+		return nil
+	}
+
+	return conn.writeErr(conn.boxer.WriteMessage(nil))
+}
+
+// noteArrival records that the remote is still there.
+func (conn *Conn) noteArrival() {
+	atomic.StoreInt64(&conn.lastArrival, time.Now().UnixNano())
+}
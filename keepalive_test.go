@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAlive(t *testing.T) {
+	r := require.New(t)
+
+	ka := WithKeepAlive(20*time.Millisecond, 100*time.Millisecond)
+	srv, cli := mkConnPair(t, []Option{ka}, []Option{ka})
+
+	// both sides stay connected without any application traffic
+	time.Sleep(300 * time.Millisecond)
+
+	r.NoError(srv.WriteMessage([]byte("still there?")))
+	msg, err := cli.ReadMessage()
+	r.NoError(err)
+	r.Equal("still there?", string(msg), "pings were not filtered")
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestKeepAliveIdleTimeout(t *testing.T) {
+	r := require.New(t)
+
+	// the server doesn't send pings
+	srv, cli := mkConnPair(t, nil, []Option{WithKeepAlive(0, 100*time.Millisecond)})
+
+	start := time.Now()
+	_, err := cli.Read(make([]byte, 1))
+	r.True(errors.Is(err, ErrIdleTimeout), "wrong error: %v", err)
+	r.True(time.Since(start) >= 100*time.Millisecond)
+
+	var netErr net.Error
+	r.True(errors.As(err, &netErr))
+	r.True(netErr.Timeout())
+
+	// the error sticks and Close still works
+	_, err = cli.ReadMessage()
+	r.Equal(ErrIdleTimeout, err)
+	r.NoError(cli.Close())
+
+	// the server sees the connection go away
+	_, err = srv.Read(make([]byte, 1))
+	r.Error(err)
+	r.NoError(srv.Close())
+}
+
+func TestKeepAliveStalledReader(t *testing.T) {
+	r := require.New(t)
+
+	ka := WithKeepAlive(20*time.Millisecond, 100*time.Millisecond)
+	srv, cli := mkConnPair(t, []Option{ka}, []Option{ka})
+
+	// more than the read-ahead buffer holds
+	for _, msg := range []string{"one", "two", "three"} {
+		r.NoError(srv.WriteMessage([]byte(msg)))
+	}
+
+	// the application doesn't read for longer than the idle timeout
+	time.Sleep(300 * time.Millisecond)
+
+	for _, want := range []string{"one", "two", "three"} {
+		msg, err := cli.ReadMessage()
+		r.NoError(err)
+		r.Equal(want, string(msg))
+	}
+
+	// and the connection is still alive
+	time.Sleep(150 * time.Millisecond)
+	r.NoError(srv.WriteMessage([]byte("four")))
+	msg, err := cli.ReadMessage()
+	r.NoError(err)
+	r.Equal("four", string(msg))
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestKeepAliveIdleAfterStall(t *testing.T) {
+	r := require.New(t)
+
+	const idle = 100 * time.Millisecond
+	conn := &Conn{pings: true}
+	last := time.Now().Add(-time.Hour)
+	atomic.StoreInt64(&conn.lastArrival, last.UnixNano())
+	atomic.StoreInt32(&conn.stalled, 1)
+
+	// the idle timer fires long after the last frame while the reader is stalled
+	r.Equal(idle, conn.idleLeft(idle, time.Now()))
+
+	// the application reads before the keepalive goroutine looks at the timer
+	conn.unstall()
+	r.True(conn.idleLeft(idle, time.Now()) > 0, "connection timed out right after the stall")
+
+	// nothing arrives for the idle timeout after that
+	r.True(conn.idleLeft(idle, time.Now().Add(idle)) <= 0)
+}
+
+func TestKeepAliveEmptyMessages(t *testing.T) {
+	r := require.New(t)
+
+	ka := WithKeepAlive(10*time.Millisecond, 0)
+	srv, cli := mkConnPair(t, []Option{ka}, []Option{ka})
+
+	r.Equal(ErrEmptyMessage, cli.WriteMessage(nil))
+	r.Equal(ErrEmptyMessage, srv.WriteMessage([]byte{}))
+
+	// empty writes send nothing
+	n, err := srv.Write(nil)
+	r.NoError(err)
+	r.Equal(0, n)
+
+	time.Sleep(50 * time.Millisecond)
+	r.NoError(srv.WriteMessage([]byte("data")))
+	msg, err := cli.ReadMessage()
+	r.NoError(err)
+	r.Equal("data", string(msg), "pings were not filtered")
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestKeepAliveOneSided(t *testing.T) {
+	r := require.New(t)
+
+	// only the server sends pings
+	srv, cli := mkConnPair(t, []Option{WithKeepAlive(10*time.Millisecond, 0)}, nil)
+
+	time.Sleep(50 * time.Millisecond)
+	r.NoError(srv.WriteMessage([]byte("data")))
+
+	// Read skips the pings
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(cli, buf)
+	r.NoError(err)
+	r.Equal("data", string(buf))
+
+	// ReadMessage returns them as empty messages
+	time.Sleep(50 * time.Millisecond)
+	r.NoError(srv.WriteMessage([]byte("more")))
+	var pings int
+	for {
+		msg, err := cli.ReadMessage()
+		r.NoError(err)
+		if len(msg) > 0 {
+			r.Equal("more", string(msg))
+			break
+		}
+		pings++
+	}
+	r.True(pings > 0, "no pings received")
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestKeepAliveInvalid(t *testing.T) {
+	r := require.New(t)
+
+	_, err := NewServer(*serverKeys, appKey, WithKeepAlive(-1, 0))
+	r.Error(err)
+
+	_, err = NewServer(*serverKeys, appKey, WithKeepAlive(time.Second, time.Second))
+	r.Error(err)
+}
@@ -0,0 +1,335 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrUnknownPeer is returned by KnownPeers.Dial if there is no key for the address.
+var ErrUnknownPeer = errors.New("secretstream: unknown peer")
+
+// KeyMismatchError is returned by KnownPeers.Learn if the address is already
+// known with a different key.
+type KeyMismatchError struct {
+	Addr   string
+	Known  ed25519.PublicKey
+	Got    ed25519.PublicKey
+	Pinned bool // whether Known was set with Pin
+}
+
+func (e *KeyMismatchError) Error() string {
+	how := "known"
+	if e.Pinned {
+		how = "pinned"
+	}
+	return fmt.Sprintf("secretstream: key mismatch for %s: %s key is %s, got %s",
+		e.Addr, how, Addr{e.Known}, Addr{e.Got})
+}
+
+// HandshakeError is returned by KnownPeers.Dial if the connection to a known
+// address was established but the handshake failed. The most likely cause is
+// that the server at Addr no longer uses Key, but it can also be a network
+// error during the handshake.
+type HandshakeError struct {
+	Addr   string
+	Key    ed25519.PublicKey // the known key Dial expected
+	Pinned bool              // whether Key was set with Pin
+	Err    error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("secretstream: handshake with %s failed, expected key %s: %v",
+		e.Addr, Addr{e.Key}, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+// KnownPeer is an entry of KnownPeers.
+type KnownPeer struct {
+	Addr   string // host:port
+	Key    ed25519.PublicKey
+	Pinned bool
+}
+
+// KnownPeers maps network addresses to the keys of the servers listening on
+// them, like the known_hosts file of SSH. The secret-handshake client has to
+// know the key of the server before it connects, so keys can't be learned from
+// the first connection. Instead, keys are learned from another source, for
+// instance announcements found by the discovery package, and trusted from
+// then on. Keys set with Pin are marked as pinned, so applications can tell
+// deliberate choices from learned keys.
+//
+// Every change is written to the backing file right away. The file is
+// replaced atomically, so it is never left half-written.
+//
+// The file has one entry per line, the address, the key as an @...ed25519 ref
+// and optionally the word "pinned", separated by spaces. Empty lines and lines
+// starting with # are ignored.
+type KnownPeers struct {
+	mu    sync.Mutex
+	path  string
+	peers map[string]KnownPeer
+}
+
+// OpenKnownPeers reads the known peers from the file at path. A missing file
+// is treated as empty and created on the first change.
+func OpenKnownPeers(path string) (*KnownPeers, error) {
+	kp := &KnownPeers{
+		path:  path,
+		peers: make(map[string]KnownPeer),
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return kp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		p, err := parseKnownPeer(text)
+		if err != nil {
+			return nil, fmt.Errorf("secretstream: %s:%d: %w", path, line, err)
+		}
+		kp.peers[p.Addr] = p
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+func parseKnownPeer(line string) (KnownPeer, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return KnownPeer{}, errors.New("expected address, key and optional pinned marker")
+	}
+
+	addr, err := normalizeAddr(fields[0])
+	if err != nil {
+		return KnownPeer{}, err
+	}
+	key, err := parseKeyRef(fields[1])
+	if err != nil {
+		return KnownPeer{}, err
+	}
+
+	p := KnownPeer{Addr: addr, Key: key}
+	if len(fields) == 3 {
+		if fields[2] != "pinned" {
+			return KnownPeer{}, fmt.Errorf("unexpected %q", fields[2])
+		}
+		p.Pinned = true
+	}
+	return p, nil
+}
+
+// parseKeyRef parses a key in the format of Addr.String.
+func parseKeyRef(ref string) (ed25519.PublicKey, error) {
+	if !strings.HasPrefix(ref, "@") || !strings.HasSuffix(ref, ".ed25519") {
+		return nil, fmt.Errorf("invalid key %q", ref)
+	}
+	key, err := base64.StdEncoding.DecodeString(ref[1 : len(ref)-len(".ed25519")])
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid key %q", ref)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// normalizeAddr brings host:port addresses into a canonical form, so that
+// lookups don't depend on the spelling of the host.
+func normalizeAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return net.JoinHostPort(strings.ToLower(host), port), nil
+}
+
+// Lookup returns the key of the server at addr.
+func (kp *KnownPeers) Lookup(addr string) (KnownPeer, bool) {
+	addr, err := normalizeAddr(addr)
+	if err != nil {
+		return KnownPeer{}, false
+	}
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	p, ok := kp.peers[addr]
+	return p, ok
+}
+
+// List returns all entries, sorted by address.
+func (kp *KnownPeers) List() []KnownPeer {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	list := make([]KnownPeer, 0, len(kp.peers))
+	for _, p := range kp.peers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+	return list
+}
+
+// Learn trusts key for addr if addr is unknown. If addr is already known with
+// key, it does nothing. If it is known with a different key, it returns a
+// *KeyMismatchError and keeps the known key.
+func (kp *KnownPeers) Learn(addr string, key ed25519.PublicKey) error {
+	return kp.set(addr, key, false)
+}
+
+// Pin sets the key for addr, replacing a previously known one.
+func (kp *KnownPeers) Pin(addr string, key ed25519.PublicKey) error {
+	return kp.set(addr, key, true)
+}
+
+func (kp *KnownPeers) set(addr string, key ed25519.PublicKey, pin bool) error {
+	addr, err := normalizeAddr(addr)
+	if err != nil {
+		return err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("secretstream: invalid key length %d", len(key))
+	}
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	old, ok := kp.peers[addr]
+	if ok && !pin {
+		if bytes.Equal(old.Key, key) {
+			return nil
+		}
+		return &KeyMismatchError{Addr: addr, Known: old.Key, Got: key, Pinned: old.Pinned}
+	}
+
+	kp.peers[addr] = KnownPeer{
+		Addr:   addr,
+		Key:    append(ed25519.PublicKey(nil), key...),
+		Pinned: pin,
+	}
+	if err := kp.save(); err != nil {
+		if ok {
+			kp.peers[addr] = old
+		} else {
+			delete(kp.peers, addr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Forget removes the entry for addr.
+func (kp *KnownPeers) Forget(addr string) error {
+	addr, err := normalizeAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	old, ok := kp.peers[addr]
+	if !ok {
+		return nil
+	}
+	delete(kp.peers, addr)
+	if err := kp.save(); err != nil {
+		kp.peers[addr] = old
+		return err
+	}
+	return nil
+}
+
+// save writes all entries to a temporary file and renames it to the path of kp.
+func (kp *KnownPeers) save() error {
+	var buf bytes.Buffer
+	buf.WriteString("# secretstream known peers: address key [pinned]\n")
+
+	addrs := make([]string, 0, len(kp.peers))
+	for addr := range kp.peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		p := kp.peers[addr]
+		fmt.Fprintf(&buf, "%s %s", p.Addr, Addr{p.Key})
+		if p.Pinned {
+			buf.WriteString(" pinned")
+		}
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(kp.path), filepath.Base(kp.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, kp.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("secretstream: failed to save known peers: %w", err)
+	}
+	return nil
+}
+
+// Dial connects to addr over TCP and shakes hands using c and the known key
+// of addr. If addr is unknown, it returns ErrUnknownPeer.
+//
+// The server only tells whether the client used the right key by completing
+// the handshake, so a server that changed its key causes a failed handshake.
+// Dial returns a *HandshakeError for it, while failing to connect returns the
+// error of the network.
+func (kp *KnownPeers) Dial(c *Client, addr string) (net.Conn, error) {
+	p, ok := kp.Lookup(addr)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, addr)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	boxed, err := c.ConnWrapper(p.Key)(conn)
+	if err != nil {
+		conn.Close()
+		return nil, &HandshakeError{Addr: p.Addr, Key: p.Key, Pinned: p.Pinned, Err: err}
+	}
+	return boxed, nil
+}
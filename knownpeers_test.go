@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKnownPeers(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := os.MkdirTemp("", "knownpeers")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "known_peers")
+
+	kp, err := OpenKnownPeers(path)
+	r.NoError(err)
+	r.Empty(kp.List())
+
+	// first use
+	r.NoError(kp.Learn("LocalHost:8008", serverKeys.Public))
+	r.NoError(kp.Learn("localhost:8008", serverKeys.Public))
+
+	p, ok := kp.Lookup("localhost:8008")
+	r.True(ok)
+	r.Equal(serverKeys.Public, p.Key)
+	r.False(p.Pinned)
+
+	// a different key is refused
+	err = kp.Learn("localhost:8008", clientKeys.Public)
+	var mismatch *KeyMismatchError
+	r.True(errors.As(err, &mismatch), "unexpected error: %v", err)
+	r.Equal("localhost:8008", mismatch.Addr)
+	r.Equal(serverKeys.Public, mismatch.Known)
+	r.Equal(clientKeys.Public, mismatch.Got)
+	r.False(mismatch.Pinned)
+
+	// unless it is pinned
+	r.NoError(kp.Pin("[::1]:8008", clientKeys.Public))
+	r.NoError(kp.Pin("localhost:8008", clientKeys.Public))
+	err = kp.Learn("localhost:8008", serverKeys.Public)
+	r.True(errors.As(err, &mismatch))
+	r.True(mismatch.Pinned)
+
+	r.NoError(kp.Learn("10.0.0.1:8008", serverKeys.Public))
+	r.NoError(kp.Forget("10.0.0.1:8008"))
+	_, ok = kp.Lookup("10.0.0.1:8008")
+	r.False(ok)
+
+	// the changes were saved
+	reopened, err := OpenKnownPeers(path)
+	r.NoError(err)
+	r.Equal(kp.List(), reopened.List())
+	r.Len(reopened.List(), 2)
+
+	// no temporary files are left behind
+	files, err := os.ReadDir(dir)
+	r.NoError(err)
+	r.Len(files, 1)
+}
+
+func TestKnownPeersInvalidFile(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := os.MkdirTemp("", "knownpeers")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "known_peers")
+
+	for _, content := range []string{
+		"localhost @q73B4YvuKsjv1clES9aWlInkxI8yTftmA9YIM5C4Obc=.ed25519\n",
+		"localhost:8008 q73B4YvuKsjv1clES9aWlInkxI8yTftmA9YIM5C4Obc=\n",
+		"localhost:8008 @q73B4YvuKsjv1clES9aWlInkxI8yTftmA9YIM5C4Obc=.ed25519 trusted\n",
+	} {
+		r.NoError(os.WriteFile(path, []byte(content), 0600))
+		_, err := OpenKnownPeers(path)
+		r.Error(err, content)
+	}
+}
+
+func TestKnownPeersDial(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := os.MkdirTemp("", "knownpeers")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	kp, err := OpenKnownPeers(filepath.Join(dir, "known_peers"))
+	r.NoError(err)
+
+	l := mkListener(t)
+	defer l.Close()
+	acceptLoop(l, func(c net.Conn) {
+		c.Write([]byte("hi"))
+		c.Close()
+	})
+	addr := netwrap.GetAddr(l.Addr(), "tcp").String()
+
+	c, err := NewClient(*clientKeys, appKey)
+	r.NoError(err)
+
+	_, err = kp.Dial(c, addr)
+	r.True(errors.Is(err, ErrUnknownPeer), "unexpected error: %v", err)
+
+	r.NoError(kp.Learn(addr, serverKeys.Public))
+	conn, err := kp.Dial(c, addr)
+	r.NoError(err)
+	defer conn.Close()
+
+	buf, err := io.ReadAll(conn)
+	r.NoError(err)
+	r.Equal("hi", string(buf))
+
+	// a server with another key fails the handshake
+	r.NoError(kp.Pin(addr, clientKeys.Public))
+	_, err = kp.Dial(c, addr)
+	var hsErr *HandshakeError
+	r.True(errors.As(err, &hsErr), "unexpected error: %v", err)
+	r.Equal(addr, hsErr.Addr)
+	r.Equal(clientKeys.Public, hsErr.Key)
+	r.True(hsErr.Pinned)
+
+	// which is not a network error
+	r.NoError(l.Close())
+	_, err = kp.Dial(c, addr)
+	r.Error(err)
+	r.False(errors.As(err, &hsErr), "unexpected error: %v", err)
+}
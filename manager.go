@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrDuplicateConn is returned by the ConnWrapper if there already is a
+// connection with the remote that is preferred over the new one.
+var ErrDuplicateConn = errors.New("secretstream: duplicate connection")
+
+// Manager keeps at most one connection per remote key, across all Clients
+// and Servers it is passed to with WithManager.
+//
+// If two peers dial each other at the same time, both of them end up with two
+// connections. The Manager keeps the one that was dialed by the peer with the
+// greater public key, comparing them bytewise. If both connections were
+// dialed by the same peer, as when it dials twice at the same time, the one
+// with the smaller session ID is kept. Both peers agree on these choices
+// without further communication, so if they both use a Manager they converge
+// on the same connection, whatever order the connections arrive in.
+//
+// A connection that received nothing for a minute is stale, and any new
+// connection with the same remote replaces it. This lets a peer that
+// restarted, and whose old connection wasn't noticed to be gone yet, connect
+// again. Staleness is judged by each side on its own, so with WithKeepAlive,
+// pings should be sent more often than that.
+//
+// The connection that isn't kept is sent a goodbye and closed; if it is the
+// new connection, the ConnWrapper returns ErrDuplicateConn.
+type Manager struct {
+	mu         sync.Mutex
+	conns      map[string]*Conn
+	staleAfter time.Duration
+}
+
+// staleAfter is the time without frames from the remote after which a Manager
+// replaces a connection.
+const staleAfter = time.Minute
+
+// NewManager returns a Manager without connections.
+func NewManager() *Manager {
+	return &Manager{
+		conns:      make(map[string]*Conn),
+		staleAfter: staleAfter,
+	}
+}
+
+// WithManager adds every authenticated connection to m.
+func WithManager(m *Manager) Option {
+	return func(o *options) error {
+		o.trackers = append(o.trackers, m)
+		return nil
+	}
+}
+
+func (m *Manager) track(c *Conn) error {
+	key := string(c.remote)
+
+	m.mu.Lock()
+	old, ok := m.conns[key]
+	if ok && !old.stale(m.staleAfter) && !preferConn(c, old) {
+		m.mu.Unlock()
+		return ErrDuplicateConn
+	}
+	m.conns[key] = c
+	m.mu.Unlock()
+
+	c.onClose(func() {
+		m.mu.Lock()
+		if m.conns[key] == c {
+			delete(m.conns, key)
+		}
+		m.mu.Unlock()
+	})
+
+	if ok {
+		old.Close()
+	}
+	return nil
+}
+
+// preferConn reports whether a should be kept over b. Both connections are
+// with the same remote.
+func preferConn(a, b *Conn) bool {
+	if cmp := bytes.Compare(a.dialer(), b.dialer()); cmp != 0 {
+		return cmp > 0
+	}
+	return bytes.Compare(a.sessionID[:], b.sessionID[:]) < 0
+}
+
+// stale reports whether nothing was received on the connection for d, or
+// since it was established if nothing was received at all.
+func (conn *Conn) stale(d time.Duration) bool {
+	last := conn.unboxer.Stats().LastFrame
+	if last.IsZero() {
+		last = conn.established
+	}
+	return time.Since(last) > d
+}
+
+// dialer returns the public key of the side that started the connection.
+func (conn *Conn) dialer() []byte {
+	if conn.role == secrethandshake.RoleClient {
+		return conn.local
+	}
+	return conn.remote
+}
+
+// Get returns the connection with remote, or nil if there is none.
+func (m *Manager) Get(remote ed25519.PublicKey) *Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conns[string(remote)]
+}
+
+// Len returns the number of connections.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"github.com/stretchr/testify/require"
+)
+
+// peer accepts and dials connections with a single key pair and Manager
+type peer struct {
+	keys    *secrethandshake.EdKeyPair
+	manager *Manager
+	client  *Client
+	l       net.Listener
+}
+
+func newPeer(t *testing.T, keys *secrethandshake.EdKeyPair) *peer {
+	r := require.New(t)
+
+	p := &peer{keys: keys, manager: NewManager()}
+
+	s, err := NewServer(*keys, appKey, WithManager(p.manager))
+	r.NoError(err)
+	p.client, err = NewClient(*keys, appKey, WithManager(p.manager))
+	r.NoError(err)
+
+	p.l, err = netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+
+	// the handshake runs in Accept
+	acceptLoop(p.l, func(net.Conn) {})
+	return p
+}
+
+func (p *peer) dial(other *peer) (net.Conn, error) {
+	return netwrap.Dial(netwrap.GetAddr(other.l.Addr(), "tcp"), p.client.ConnWrapper(other.keys.Public))
+}
+
+// checkConverged makes sure that a and b kept the same single connection with each other
+func checkConverged(t *testing.T, a, b *peer) {
+	r := require.New(t)
+
+	var aConn, bConn *Conn
+	eventually(t, func() bool {
+		aConn, bConn = a.manager.Get(b.keys.Public), b.manager.Get(a.keys.Public)
+		return aConn != nil && bConn != nil &&
+			bytes.Equal(aConn.ConnectionState().SessionID, bConn.ConnectionState().SessionID)
+	})
+	r.Equal(1, a.manager.Len())
+	r.Equal(1, b.manager.Len())
+
+	// the kept connection works
+	go aConn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	bConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.ReadFull(bConn, buf)
+	r.NoError(err)
+	r.Equal("hello", string(buf))
+}
+
+func TestManagerSimultaneousDial(t *testing.T) {
+	r := require.New(t)
+
+	a, b := newPeer(t, clientKeys), newPeer(t, serverKeys)
+	defer a.l.Close()
+	defer b.l.Close()
+
+	var wg sync.WaitGroup
+	var aErr, bErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, aErr = a.dial(b)
+	}()
+	go func() {
+		defer wg.Done()
+		_, bErr = b.dial(a)
+	}()
+	wg.Wait()
+
+	// at most one of the dials may be refused as a duplicate
+	r.False(aErr != nil && bErr != nil, "both dials failed: %v, %v", aErr, bErr)
+
+	checkConverged(t, a, b)
+
+	// the connection dialed by the greater key wins
+	kept := a.manager.Get(b.keys.Public)
+	r.Equal(bytes.Compare(a.keys.Public, b.keys.Public) > 0, kept.role == secrethandshake.RoleClient)
+}
+
+func TestManagerRedial(t *testing.T) {
+	r := require.New(t)
+
+	a, b := newPeer(t, clientKeys), newPeer(t, serverKeys)
+	defer a.l.Close()
+	defer b.l.Close()
+
+	// a redial is either refused or replaces the old connection on both sides
+	for i := 0; i < 10; i++ {
+		c, err := a.dial(b)
+		if err != nil {
+			// netwrap wraps the error without Unwrap
+			r.Contains(err.Error(), ErrDuplicateConn.Error())
+		} else {
+			defer c.Close()
+		}
+		checkConverged(t, a, b)
+	}
+
+	// closing the connection removes it
+	r.NoError(a.manager.Get(b.keys.Public).Close())
+	r.Equal(0, a.manager.Len())
+	eventually(t, func() bool {
+		c := b.manager.Get(a.keys.Public)
+		if c == nil {
+			return true
+		}
+		// b only notices the goodbye when reading
+		c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		if _, err := c.Read(make([]byte, 1)); err == io.EOF {
+			c.Close()
+		}
+		return false
+	})
+}
+
+func TestManagerSimultaneousRedial(t *testing.T) {
+	r := require.New(t)
+
+	// two connections dialed by the same client, whose handshakes finish in a
+	// different order on each side
+	srv1, cli1 := mkConnPair(t, nil, nil)
+	srv2, cli2 := mkConnPair(t, nil, nil)
+	for _, c := range []*Conn{srv1, cli1, srv2, cli2} {
+		defer c.Close()
+	}
+
+	client, server := NewManager(), NewManager()
+	r.NoError(client.track(cli1))
+	cliErr := client.track(cli2)
+	r.NoError(server.track(srv2))
+	srvErr := server.track(srv1)
+
+	// exactly one of the late connections is refused, and both sides keep the same one
+	r.True((cliErr == nil) != (srvErr == nil), "errors: %v, %v", cliErr, srvErr)
+	kept, other := client.Get(serverKeys.Public), server.Get(clientKeys.Public)
+	r.Equal(kept.ConnectionState().SessionID, other.ConnectionState().SessionID)
+	r.Equal(1, client.Len())
+	r.Equal(1, server.Len())
+}
+
+func TestManagerStale(t *testing.T) {
+	r := require.New(t)
+
+	a, b := newPeer(t, clientKeys), newPeer(t, serverKeys)
+	defer a.l.Close()
+	defer b.l.Close()
+	a.manager.staleAfter = 10 * time.Millisecond
+	b.manager.staleAfter = 10 * time.Millisecond
+
+	first, err := a.dial(b)
+	r.NoError(err)
+	defer first.Close()
+	checkConverged(t, a, b)
+	time.Sleep(20 * time.Millisecond)
+
+	// a connection that received nothing lately is replaced, whatever its session ID
+	second, err := a.dial(b)
+	r.NoError(err)
+	defer second.Close()
+	checkConverged(t, a, b)
+	r.Equal(second, a.manager.Get(b.keys.Public))
+}
@@ -103,7 +103,7 @@ func TestNet(t *testing.T) {
 		check(err)
 
 		if string(buf) != testData {
-			fmt.Errorf("server read wrong bytes: %x", buf)
+			check(fmt.Errorf("server read wrong bytes: %x", buf))
 			return
 		}
 
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ssbc/go-secretstream/internal/securemem"
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Option configures a Client or a Server.
+type Option func(*options) error
+
+type options struct {
+	readAhead int
+	keepAlive keepAlive
+
+	newHooks  func(net.Addr) secrethandshake.Hooks
+	audit     AuditSink
+	authorize func(ed25519.PublicKey) error
+	ephemeral *secrethandshake.EphemeralKeyPool
+
+	secureMemory bool
+
+	trackers []tracker
+}
+
+// tracker is told about every new connection. If track fails, the connection is refused.
+type tracker interface {
+	track(*Conn) error
+}
+
+func newOptions(opts []Option) (options, error) {
+	var o options
+	for i, opt := range opts {
+		if err := opt(&o); err != nil {
+			return o, fmt.Errorf("secretstream: failed to apply option %d: %w", i, err)
+		}
+	}
+	return o, nil
+}
+
+// WithReadAhead makes each connection decrypt incoming boxstream frames in the
+// background, keeping up to n of them ready for Read. Once n frames are
+// buffered, reading from the network pauses until the application catches up.
+// Read deadlines only limit how long Read waits for a frame, the background
+// reader isn't affected by them. n == 0 disables read-ahead, which is the
+// default.
+func WithReadAhead(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return fmt.Errorf("read-ahead depth must not be negative (got %d)", n)
+		}
+		o.readAhead = n
+		return nil
+	}
+}
+
+// WithHandshakeHooks makes every handshake report its progress to the Hooks
+// returned by newHooks, which is called with the network address of the remote
+// before the handshake starts. It may return the same Hooks for all
+// connections, for instance to update metrics, or new ones per connection, for
+// instance to trace each handshake.
+func WithHandshakeHooks(newHooks func(remote net.Addr) secrethandshake.Hooks) Option {
+	return func(o *options) error {
+		o.newHooks = newHooks
+		return nil
+	}
+}
+
+// WithAuthorizer makes a Server call authorize with the key of every client
+// that completed the authentication. If it returns an error, the server
+// doesn't accept the client and the ConnWrapper returns the error, wrapped in
+// a secrethandshake.ErrUnauthorized. Clients ignore this option.
+func WithAuthorizer(authorize func(remote ed25519.PublicKey) error) Option {
+	return func(o *options) error {
+		o.authorize = authorize
+		return nil
+	}
+}
+
+// WithEphemeralKeyPool keeps up to size ephemeral key pairs generated ahead
+// of time, so that handshakes don't wait for them. Generating a key pair is a
+// small part of a handshake, so this doesn't measurably lower its latency.
+func WithEphemeralKeyPool(size int) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return fmt.Errorf("ephemeral key pool size must be positive (got %d)", size)
+		}
+		o.ephemeral = secrethandshake.NewEphemeralKeyPool(size)
+		return nil
+	}
+}
+
+// WithSecureMemory keeps secrets in memory that is locked, so it is never
+// written to swap, and wiped once it is no longer needed: the secrets of each
+// handshake until it ends, the session keys of each connection until it is
+// closed and, for NewServer and NewClient, a copy of the long-term private
+// key until Server.Shutdown or Client.Close. Callers should overwrite their
+// own copy of it. See secrethandshake.LockedIdentity for what isn't covered.
+//
+// It is only supported on Linux, and fails if the process may not lock
+// memory, see RLIMIT_MEMLOCK. Establishing a connection fails once the limit
+// is reached.
+func WithSecureMemory() Option {
+	return func(o *options) error {
+		// find out early if locking is allowed
+		buf, err := securemem.Alloc(1, true)
+		if err != nil {
+			return err
+		}
+		buf.Free()
+
+		o.secureMemory = true
+		return nil
+	}
+}
+
+// identity returns the Identity that NewServer and NewClient use for kp, and
+// the LockedIdentity to close with them, if any.
+func (o options) identity(kp secrethandshake.EdKeyPair) (secrethandshake.Identity, *secrethandshake.LockedIdentity, error) {
+	if o.secureMemory {
+		id, err := secrethandshake.NewLockedIdentity(kp)
+		return id, id, err
+	}
+	id, err := secrethandshake.NewPrecomputedIdentity(kp)
+	return id, nil, err
+}
+
+// stateOptions returns the secrethandshake options for a handshake over conn.
+func (o options) stateOptions(conn net.Conn) []secrethandshake.StateOption {
+	var opts []secrethandshake.StateOption
+	if o.newHooks != nil {
+		opts = append(opts, secrethandshake.WithHooks(o.newHooks(conn.RemoteAddr())))
+	}
+	if o.authorize != nil {
+		opts = append(opts, secrethandshake.WithAuthorizer(o.authorize))
+	}
+	if o.ephemeral != nil {
+		opts = append(opts, secrethandshake.WithEphemeralKeyPool(o.ephemeral))
+	}
+	if o.secureMemory {
+		opts = append(opts, secrethandshake.WithSecureMemory())
+	}
+	return opts
+}
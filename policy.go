@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	// ErrKeyRevoked is returned by Policy.Authorize for keys in the [deny] section.
+	ErrKeyRevoked = errors.New("secretstream: key is revoked")
+
+	// ErrKeyNotAllowed is returned by Policy.Authorize for keys missing from the [allow] section.
+	ErrKeyNotAllowed = errors.New("secretstream: key is not allowed")
+)
+
+// Policy decides which clients may connect to a Server, based on a file that
+// operators can edit while the server is running. Pass its Authorize method
+// to WithAuthorizer.
+//
+// The file lists @...ed25519 refs, one per line, in an [allow] and a [deny]
+// section. Keys in the deny section are always refused. If there is an allow
+// section, only the keys in it are accepted, even if it is empty. Without an
+// allow section, all keys that aren't denied are accepted. Empty lines and
+// lines starting with # are ignored.
+//
+//	# the team
+//	[allow]
+//	@q73B4YvuKsjv1clES9aWlInkxI8yTftmA9YIM5C4Obc=.ed25519
+//
+//	[deny]
+//	@H0zuAOaXJ8cIDjXbpmHpAwWHgVuaMN4KYnGCRP+WNNk=.ed25519
+type Policy struct {
+	path string
+
+	mu       sync.RWMutex
+	allow    map[string]struct{} // nil if there is no allow section
+	deny     map[string]struct{}
+	modTime  time.Time
+	size     int64
+	enforced []*Registry
+}
+
+// LoadPolicy reads the policy from the file at path.
+func LoadPolicy(path string) (*Policy, error) {
+	p := &Policy{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Authorize returns nil if remote may connect.
+func (p *Policy) Authorize(remote ed25519.PublicKey) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if _, ok := p.deny[string(remote)]; ok {
+		return ErrKeyRevoked
+	}
+	if p.allow != nil {
+		if _, ok := p.allow[string(remote)]; !ok {
+			return ErrKeyNotAllowed
+		}
+	}
+	return nil
+}
+
+// Reload reads the file again. If it can't be read or parsed, the previous
+// policy stays in place. Afterwards, connections with keys that are no
+// longer authorized are closed in all registries passed to Enforce.
+func (p *Policy) Reload() error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	allow, deny, err := parsePolicy(f)
+	if err != nil {
+		return fmt.Errorf("secretstream: %s: %w", p.path, err)
+	}
+
+	p.mu.Lock()
+	p.allow, p.deny = allow, deny
+	p.modTime, p.size = fi.ModTime(), fi.Size()
+	registries := p.enforced
+	p.mu.Unlock()
+
+	for _, r := range registries {
+		p.disconnectRevoked(r)
+	}
+	return nil
+}
+
+func parsePolicy(f *os.File) (allow, deny map[string]struct{}, err error) {
+	deny = make(map[string]struct{})
+
+	var section map[string]struct{}
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#"):
+			continue
+
+		case text == "[allow]":
+			if allow == nil {
+				allow = make(map[string]struct{})
+			}
+			section = allow
+
+		case text == "[deny]":
+			section = deny
+
+		default:
+			if section == nil {
+				return nil, nil, fmt.Errorf("line %d: key outside of [allow] or [deny] section", line)
+			}
+			key, err := parseKeyRef(text)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			section[string(key)] = struct{}{}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+	return allow, deny, nil
+}
+
+// Enforce makes the policy close the connections in r with keys that are no
+// longer authorized, right away and after every reload. The connections are
+// sent a goodbye.
+func (p *Policy) Enforce(r *Registry) {
+	p.mu.Lock()
+	p.enforced = append(p.enforced, r)
+	p.mu.Unlock()
+
+	p.disconnectRevoked(r)
+}
+
+func (p *Policy) disconnectRevoked(r *Registry) {
+	for _, key := range r.Peers() {
+		if p.Authorize(key) != nil {
+			r.Disconnect(key)
+		}
+	}
+}
+
+// changed reports whether the file was modified since it was last loaded.
+func (p *Policy) changed() bool {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		// let Reload report the error
+		return true
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !fi.ModTime().Equal(p.modTime) || fi.Size() != p.size
+}
+
+// Watch reloads the policy whenever the process receives SIGHUP and when it
+// notices that the file changed, which it checks every interval. An interval
+// of 0 only reloads on SIGHUP, a negative one is an error. Errors from
+// reloading are passed to onError, which may be nil. Watch blocks until ctx is
+// done and returns ctx.Err().
+func (p *Policy) Watch(ctx context.Context, interval time.Duration, onError func(error)) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	return p.watch(ctx, interval, hup, onError)
+}
+
+func (p *Policy) watch(ctx context.Context, interval time.Duration, hup <-chan os.Signal, onError func(error)) error {
+	if interval < 0 {
+		return fmt.Errorf("secretstream: negative policy watch interval %s", interval)
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-hup:
+
+		case <-tick:
+			if !p.changed() {
+				continue
+			}
+		}
+
+		err := p.Reload()
+		// report a broken file once, not on every tick
+		if err != nil && onError != nil && (lastErr == nil || err.Error() != lastErr.Error()) {
+			onError(err)
+		}
+		lastErr = err
+	}
+}
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+// writePolicy writes a policy file with the passed sections to path
+func writePolicy(t *testing.T, path string, allow, deny []ed25519.PublicKey) {
+	var b strings.Builder
+	b.WriteString("# test policy\n")
+	if allow != nil {
+		b.WriteString("[allow]\n")
+		for _, k := range allow {
+			b.WriteString(Addr{k}.String() + "\n")
+		}
+	}
+	b.WriteString("\n[deny]\n")
+	for _, k := range deny {
+		b.WriteString(Addr{k}.String() + "\n")
+	}
+	require.NoError(t, ioutil.WriteFile(path, []byte(b.String()), 0600))
+}
+
+func TestPolicy(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "policy")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy")
+
+	// no allow section
+	writePolicy(t, path, nil, []ed25519.PublicKey{serverKeys.Public})
+	p, err := LoadPolicy(path)
+	r.NoError(err)
+	r.NoError(p.Authorize(clientKeys.Public))
+	r.Equal(ErrKeyRevoked, p.Authorize(serverKeys.Public))
+
+	// an empty allow section allows nobody
+	writePolicy(t, path, []ed25519.PublicKey{}, nil)
+	r.NoError(p.Reload())
+	r.Equal(ErrKeyNotAllowed, p.Authorize(clientKeys.Public))
+
+	// deny wins
+	writePolicy(t, path, []ed25519.PublicKey{clientKeys.Public, serverKeys.Public}, []ed25519.PublicKey{serverKeys.Public})
+	r.NoError(p.Reload())
+	r.NoError(p.Authorize(clientKeys.Public))
+	r.Equal(ErrKeyRevoked, p.Authorize(serverKeys.Public))
+
+	// a broken file keeps the previous policy
+	for _, content := range []string{
+		Addr{clientKeys.Public}.String() + "\n",
+		"[deny]\n@invalid.ed25519\n",
+	} {
+		r.NoError(ioutil.WriteFile(path, []byte(content), 0600))
+		r.Error(p.Reload(), content)
+		r.NoError(p.Authorize(clientKeys.Public))
+		r.Equal(ErrKeyRevoked, p.Authorize(serverKeys.Public))
+	}
+
+	_, err = LoadPolicy(filepath.Join(dir, "missing"))
+	r.Error(err)
+}
+
+func TestPolicyServer(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "policy")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy")
+
+	writePolicy(t, path, []ed25519.PublicKey{clientKeys.Public}, nil)
+	p, err := LoadPolicy(path)
+	r.NoError(err)
+
+	reg := NewRegistry(0)
+	p.Enforce(reg)
+
+	l := mkListener(t, WithAuthorizer(p.Authorize), WithRegistry(reg))
+	defer l.Close()
+	acceptLoop(l, func(c net.Conn) {})
+
+	cli, err := dial(t, l)
+	r.NoError(err)
+	defer cli.Close()
+	eventually(t, func() bool { return reg.Len() == 1 })
+
+	// revoking the key disconnects the client and refuses new connections
+	writePolicy(t, path, []ed25519.PublicKey{clientKeys.Public}, []ed25519.PublicKey{clientKeys.Public})
+	r.NoError(p.Reload())
+	r.Equal(0, reg.Len())
+
+	cli.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = cli.Read(make([]byte, 1))
+	r.Equal(io.EOF, err)
+
+	_, err = dial(t, l)
+	r.Error(err)
+}
+
+func TestPolicyWatch(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "policy")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy")
+
+	writePolicy(t, path, nil, nil)
+	p, err := LoadPolicy(path)
+	r.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hup := make(chan os.Signal)
+	errs := make(chan error, 10)
+	watchErr := make(chan error)
+	go func() {
+		watchErr <- p.watch(ctx, 10*time.Millisecond, hup, func(err error) { errs <- err })
+	}()
+
+	// a changed file is picked up
+	writePolicy(t, path, nil, []ed25519.PublicKey{clientKeys.Public})
+	eventually(t, func() bool { return p.Authorize(clientKeys.Public) != nil })
+
+	// a broken file is reported once
+	r.NoError(ioutil.WriteFile(path, []byte("broken\n"), 0600))
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("expected reload error")
+	}
+	hup <- syscall.SIGHUP
+	select {
+	case err := <-errs:
+		t.Fatal("error reported twice:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// SIGHUP reloads even if the file seems unchanged
+	writePolicy(t, path, nil, nil)
+	fi, err := os.Stat(path)
+	r.NoError(err)
+	p.mu.Lock()
+	p.modTime, p.size = fi.ModTime(), fi.Size()
+	p.mu.Unlock()
+	r.Error(p.Authorize(clientKeys.Public))
+
+	hup <- syscall.SIGHUP
+	eventually(t, func() bool { return p.Authorize(clientKeys.Public) == nil })
+
+	cancel()
+	r.Equal(context.Canceled, <-watchErr)
+}
+
+func TestPolicyWatchInterval(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "policy")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy")
+
+	writePolicy(t, path, nil, nil)
+	p, err := LoadPolicy(path)
+	r.NoError(err)
+
+	r.Error(p.watch(context.Background(), -time.Second, nil, nil))
+
+	// without an interval, only SIGHUP reloads
+	ctx, cancel := context.WithCancel(context.Background())
+	hup := make(chan os.Signal)
+	watchErr := make(chan error)
+	go func() {
+		watchErr <- p.watch(ctx, 0, hup, nil)
+	}()
+
+	writePolicy(t, path, nil, []ed25519.PublicKey{clientKeys.Public})
+	time.Sleep(50 * time.Millisecond)
+	r.NoError(p.Authorize(clientKeys.Public))
+
+	hup <- syscall.SIGHUP
+	eventually(t, func() bool { return p.Authorize(clientKeys.Public) != nil })
+
+	cancel()
+	r.Equal(context.Canceled, <-watchErr)
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// frame is a decrypted boxstream message or the error that ended the stream.
+type frame struct {
+	msg []byte
+	err error
+}
+
+// readAhead reads and decrypts frames from the unboxer until it fails or the
+// connection is closed. It blocks once the frames buffer is full, so a slow
+// reader applies backpressure all the way down to the network. While it is
+// blocked, the keepalive idle timeout is suspended.
+func (conn *Conn) readAhead() {
+	for {
+		msg, err := conn.unboxer.ReadMessage()
+
+		// with keepalive enabled, note the arrival and drop pings
+		if conn.pings && err == nil {
+			conn.noteArrival()
+
+			if len(msg) == 0 {
+				continue
+			}
+		}
+
+		if err == io.EOF {
+			conn.noteEOF()
+		}
+
+		f := frame{msg: msg, err: err}
+		select {
+		case conn.frames <- f:
+		default:
+			atomic.StoreInt32(&conn.stalled, 1)
+			select {
+			case conn.frames <- f:
+			case <-conn.done:
+				return
+			}
+			conn.unstall()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// unstall ends a stall of the read-ahead goroutine. Pings may have queued up in
+// the meantime, so they get a full idle timeout to arrive. The arrival is noted
+// before the stall ends, so the keepalive goroutine never sees an old arrival
+// on a connection that isn't stalled.
+func (conn *Conn) unstall() {
+	if conn.pings {
+		conn.noteArrival()
+	}
+	atomic.StoreInt32(&conn.stalled, 0)
+}
+
+// nextFrame returns the next message from the read-ahead buffer. Once an error
+// was received it is returned for every following call. Timeouts of the read
+// deadline are not sticky.
+func (conn *Conn) nextFrame() ([]byte, error) {
+	if conn.readErr != nil {
+		return nil, conn.readErr
+	}
+
+	for {
+		t, changed := conn.readDeadline.get()
+
+		var (
+			expired <-chan time.Time
+			timer   *time.Timer
+		)
+		if !t.IsZero() {
+			wait := time.Until(t)
+			if wait <= 0 {
+				return nil, conn.timeoutErr()
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+
+		select {
+		case f := <-conn.frames:
+			stopTimer(timer)
+			if f.err != nil {
+				// reading failed because the connection was closed locally
+				if cerr := conn.closedErr(); cerr != nil {
+					f.err = cerr
+				}
+				conn.readErr = f.err
+			}
+			return f.msg, f.err
+
+		case <-conn.done:
+			stopTimer(timer)
+			conn.readErr = conn.closedErr()
+			return nil, conn.readErr
+
+		case <-expired:
+			return nil, conn.timeoutErr()
+
+		case <-changed:
+			stopTimer(timer)
+		}
+	}
+}
+
+// timeoutErr is the error of a read that hit the read deadline, as the
+// underlying net.Conn would return it.
+func (conn *Conn) timeoutErr() error {
+	return &net.OpError{
+		Op:     "read",
+		Net:    conn.conn.LocalAddr().Network(),
+		Source: conn.conn.LocalAddr(),
+		Addr:   conn.conn.RemoteAddr(),
+		Err:    os.ErrDeadlineExceeded,
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+// deadline is a read deadline that readers can wait for.
+type deadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{} // closed and replaced whenever t is set
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+	d.mu.Unlock()
+}
+
+// get returns the deadline and a channel that is closed once it is set again.
+func (d *deadline) get() (time.Time, <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.t, d.changed
+}
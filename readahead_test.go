@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAhead(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, []Option{WithReadAhead(4)})
+
+	// 1 MiB
+	testData := make([]byte, 1024*1024)
+	rand.Read(testData)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := srv.Write(testData)
+		if err == nil {
+			err = srv.Close()
+		}
+		errc <- err
+	}()
+
+	recData, err := io.ReadAll(cli)
+	r.NoError(err)
+	r.Equal(testData, recData, "client read wrong bytes")
+	r.NoError(<-errc)
+
+	// the error sticks
+	_, err = cli.Read(make([]byte, 1))
+	r.Equal(io.EOF, err)
+
+	r.NoError(cli.Close())
+}
+
+func TestReadAheadBackpressure(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, []Option{WithReadAhead(2)})
+
+	msg := make([]byte, 64)
+	for i := 0; i < 10; i++ {
+		_, err := srv.Write(msg)
+		r.NoError(err)
+	}
+
+	// the goroutine only buffers as many frames as configured
+	eventually(t, func() bool { return len(cli.frames) == 2 })
+	time.Sleep(50 * time.Millisecond)
+	r.Len(cli.frames, 2)
+
+	buf := make([]byte, len(msg))
+	for i := 0; i < 10; i++ {
+		_, err := io.ReadFull(cli, buf)
+		r.NoError(err)
+	}
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}
+
+func TestReadAheadClose(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, []Option{WithReadAhead(1)})
+
+	// close while the goroutine waits for the network
+	r.NoError(cli.Close())
+
+	_, err := cli.Read(make([]byte, 1))
+	r.Error(err)
+
+	r.NoError(srv.Close())
+}
+
+func TestReadAheadInvalid(t *testing.T) {
+	_, err := NewClient(*clientKeys, appKey, WithReadAhead(-1))
+	require.Error(t, err)
+}
+
+func TestReadDeadline(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"direct":    nil,
+		"readAhead": {WithReadAhead(2)},
+		"keepAlive": {WithKeepAlive(10*time.Millisecond, time.Second)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+
+			srv, cli := mkConnPair(t, opts, opts)
+			defer srv.Close()
+			defer cli.Close()
+
+			r.NoError(cli.SetReadDeadline(time.Now().Add(20 * time.Millisecond)))
+			_, err := cli.Read(make([]byte, 1))
+			r.True(errors.Is(err, os.ErrDeadlineExceeded), "unexpected error: %v", err)
+			var netErr net.Error
+			r.True(errors.As(err, &netErr) && netErr.Timeout())
+
+			// the connection survives the timeout
+			time.Sleep(50 * time.Millisecond)
+			r.NoError(srv.WriteMessage([]byte("hi")))
+			r.NoError(cli.SetReadDeadline(time.Time{}))
+			msg, err := cli.ReadMessage()
+			r.NoError(err)
+			r.Equal("hi", string(msg))
+
+			// a new deadline applies to a blocked read
+			errc := make(chan error, 1)
+			go func() {
+				_, err := cli.Read(make([]byte, 1))
+				errc <- err
+			}()
+			time.Sleep(20 * time.Millisecond)
+			r.NoError(cli.SetDeadline(time.Now().Add(20 * time.Millisecond)))
+			select {
+			case err := <-errc:
+				r.True(errors.Is(err, os.ErrDeadlineExceeded), "unexpected error: %v", err)
+			case <-time.After(time.Second):
+				t.Fatal("read not interrupted by the deadline")
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrTooManyConns is returned by the ConnWrapper if the remote already has
+// the maximum number of connections in a Registry.
+var ErrTooManyConns = errors.New("secretstream: too many connections for this key")
+
+// Registry keeps track of live connections, indexed by the long-term key of
+// the remote. A connection is live from the end of the handshake until it is
+// closed locally, so applications should close connections once reading from
+// them failed.
+type Registry struct {
+	mu        sync.Mutex
+	maxPerKey int
+	conns     map[string][]*Conn
+}
+
+// NewRegistry returns an empty Registry that allows at most maxPerKey
+// simultaneous connections per remote key, or any number if maxPerKey is 0.
+func NewRegistry(maxPerKey int) *Registry {
+	return &Registry{
+		maxPerKey: maxPerKey,
+		conns:     make(map[string][]*Conn),
+	}
+}
+
+// WithRegistry adds every authenticated connection to r. Connections beyond
+// the limit of r are sent a goodbye and closed, and the ConnWrapper returns
+// ErrTooManyConns.
+func WithRegistry(r *Registry) Option {
+	return func(o *options) error {
+		o.trackers = append(o.trackers, r)
+		return nil
+	}
+}
+
+func (r *Registry) track(c *Conn) error {
+	key := string(c.remote)
+
+	r.mu.Lock()
+	if r.maxPerKey > 0 && len(r.conns[key]) >= r.maxPerKey {
+		r.mu.Unlock()
+		return ErrTooManyConns
+	}
+	r.conns[key] = append(r.conns[key], c)
+	r.mu.Unlock()
+
+	c.onClose(func() { r.remove(c) })
+	return nil
+}
+
+func (r *Registry) remove(c *Conn) {
+	key := string(c.remote)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conns := r.conns[key]
+	for i, other := range conns {
+		if other == c {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(r.conns, key)
+	} else {
+		r.conns[key] = conns
+	}
+}
+
+// Peers returns the keys of all remotes with live connections, sorted bytewise.
+func (r *Registry) Peers() []ed25519.PublicKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	peers := make([]ed25519.PublicKey, 0, len(r.conns))
+	for key := range r.conns {
+		peers = append(peers, ed25519.PublicKey(key))
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i], peers[j]) < 0
+	})
+	return peers
+}
+
+// Conns returns the live connections with remote, oldest first.
+func (r *Registry) Conns(remote ed25519.PublicKey) []*Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*Conn(nil), r.conns[string(remote)]...)
+}
+
+// Len returns the number of live connections.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+	for _, conns := range r.conns {
+		n += len(conns)
+	}
+	return n
+}
+
+// Disconnect sends a goodbye to every connection with remote and closes it.
+// It returns the first error that closing a connection returned.
+func (r *Registry) Disconnect(remote ed25519.PublicKey) error {
+	var firstErr error
+	for _, c := range r.Conns(remote) {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
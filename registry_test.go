@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestRegistry(t *testing.T) {
+	r := require.New(t)
+
+	reg := NewRegistry(2)
+	l := mkListener(t, WithRegistry(reg))
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	acceptLoop(l, func(c net.Conn) { accepted <- c })
+
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := dial(t, l)
+		r.NoError(err)
+		clients = append(clients, c)
+		<-accepted
+	}
+
+	r.Equal(2, reg.Len())
+	r.Equal([]ed25519.PublicKey{clientKeys.Public}, reg.Peers())
+	r.Len(reg.Conns(clientKeys.Public), 2)
+
+	// the third connection with the same key is refused after the handshake
+	third, err := dial(t, l)
+	r.NoError(err)
+	_, err = third.Read(make([]byte, 1))
+	r.Equal(io.EOF, err, "expected goodbye")
+	r.NoError(third.Close())
+	r.Equal(2, reg.Len())
+
+	// closing a connection removes it
+	srvConns := reg.Conns(clientKeys.Public)
+	r.NoError(srvConns[0].Close())
+	r.Len(reg.Conns(clientKeys.Public), 1)
+	r.Equal(srvConns[1], reg.Conns(clientKeys.Public)[0])
+
+	// disconnecting sends a goodbye to the remaining one
+	r.NoError(reg.Disconnect(clientKeys.Public))
+	r.Equal(0, reg.Len())
+	r.Empty(reg.Peers())
+
+	for _, c := range clients {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := c.Read(make([]byte, 1))
+		r.Equal(io.EOF, err)
+		r.NoError(c.Close())
+	}
+}
@@ -40,86 +40,161 @@ func GenEdKeyPair(r io.Reader) (*EdKeyPair, error) {
 	return &EdKeyPair{pubSrv, secSrv}, nil
 }
 
-// Client shakes hands using the cryptographic identity specified in s using conn in the client role
-func Client(state *State, conn io.ReadWriter) (err error) {
+// Client shakes hands using the cryptographic identity specified in s using conn in the client role.
+// The state is wiped when it returns and can't be used again.
+func Client(state *State, conn io.ReadWriter) (res *Result, err error) {
+	var stage Stage
+	state.hooks.HandshakeStarted(RoleClient)
+	defer func() {
+		state.report(stage, err)
+		state.Wipe()
+	}()
+
 	// send challenge
-	_, err = conn.Write(state.createChallenge())
+	stage = StageSendChallenge
+	challenge := state.createChallenge()
+	_, err = conn.Write(challenge)
 	if err != nil {
-		return ErrProcessing{where: "sending challenge", cause: err}
+		return nil, ErrProcessing{where: "sending challenge", cause: err}
 	}
+	state.hooks.MessageSent(stage, len(challenge))
 
 	// recv challenge
+	stage = StageReceiveChallenge
 	chalResp := make([]byte, ChallengeLength)
 	_, err = io.ReadFull(conn, chalResp)
 	if err != nil {
-		return ErrProcessing{where: "receiving challenge", cause: err}
+		return nil, ErrProcessing{where: "receiving challenge", cause: err}
 	}
+	state.hooks.MessageReceived(stage, len(chalResp))
 
 	// verify challenge
+	stage = StageVerifyChallenge
 	if !state.verifyChallenge(chalResp) {
-		return ErrProtocol{0}
+		return nil, ErrProtocol{0}
 	}
 
 	// send authentication vector
-	_, err = conn.Write(state.createClientAuth())
+	stage = StageSendClientAuth
+	clientAuth, err := state.createClientAuth()
+	if _, ok := err.(ErrIdentity); ok {
+		return nil, err
+	} else if err != nil {
+		return nil, ErrEncoding{what: "client hello", cause: err}
+	}
+	_, err = conn.Write(clientAuth)
 	if err != nil {
-		return ErrProcessing{where: "sending client hello", cause: err}
+		return nil, ErrProcessing{where: "sending client hello", cause: err}
 	}
+	state.hooks.MessageSent(stage, len(clientAuth))
 
 	// recv authentication vector
+	stage = StageReceiveServerAccept
 	boxedSig := make([]byte, ServerAuthLength)
 	_, err = io.ReadFull(conn, boxedSig)
 	if err != nil {
-		return ErrProcessing{where: "receiving server auth", cause: err}
+		return nil, ErrProcessing{where: "receiving server auth", cause: err}
 	}
+	state.hooks.MessageReceived(stage, len(boxedSig))
 
 	// authenticate remote
-	if !state.verifyServerAccept(boxedSig) {
-		return ErrProtocol{1}
+	stage = StageVerifyServerAccept
+	ok, err := state.verifyServerAccept(boxedSig)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrProtocol{1}
 	}
 
 	state.cleanSecrets()
-	return nil
+	return newResult(state)
 }
 
-// Server shakes hands using the cryptographic identity specified in s using conn in the server role
-func Server(state *State, conn io.ReadWriter) (err error) {
+// Server shakes hands using the cryptographic identity specified in s using conn in the server role.
+// The state is wiped when it returns and can't be used again.
+func Server(state *State, conn io.ReadWriter) (res *Result, err error) {
+	var stage Stage
+	state.hooks.HandshakeStarted(RoleServer)
+	defer func() {
+		state.report(stage, err)
+		state.Wipe()
+	}()
+
 	// recv challenge
+	stage = StageReceiveChallenge
 	challenge := make([]byte, ChallengeLength)
 	_, err = io.ReadFull(conn, challenge)
 	if err != nil {
-		return ErrProcessing{where: "receiving challenge", cause: err}
+		return nil, ErrProcessing{where: "receiving challenge", cause: err}
 	}
+	state.hooks.MessageReceived(stage, len(challenge))
 
 	// verify challenge
+	stage = StageVerifyChallenge
 	if !state.verifyChallenge(challenge) {
-		return ErrProtocol{0}
+		return nil, ErrProtocol{0}
 	}
 
 	// send challenge
-	_, err = conn.Write(state.createChallenge())
+	stage = StageSendChallenge
+	chalResp := state.createChallenge()
+	_, err = conn.Write(chalResp)
 	if err != nil {
-		return ErrProcessing{where: "sending challenge", cause: err}
+		return nil, ErrProcessing{where: "sending challenge", cause: err}
 	}
+	state.hooks.MessageSent(stage, len(chalResp))
 
 	// recv authentication vector
+	stage = StageReceiveClientAuth
 	hello := make([]byte, ClientAuthLength)
 	_, err = io.ReadFull(conn, hello)
 	if err != nil {
-		return ErrProcessing{where: "receiving client hello", cause: err}
+		return nil, ErrProcessing{where: "receiving client hello", cause: err}
 	}
+	state.hooks.MessageReceived(stage, len(hello))
 
 	// authenticate remote
-	if !state.verifyClientAuth(hello) {
-		return ErrProtocol{1}
+	stage = StageVerifyClientAuth
+	ok, err := state.verifyClientAuth(hello)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrProtocol{1}
+	}
+	if state.authorize != nil {
+		remote := append(ed25519.PublicKey(nil), state.remotePublic...)
+		if aerr := state.authorize(remote); aerr != nil {
+			return nil, ErrUnauthorized{Remote: remote, cause: aerr}
+		}
 	}
 
 	// accept
-	_, err = conn.Write(state.createServerAccept())
+	stage = StageSendServerAccept
+	serverAccept, err := state.createServerAccept()
+	if _, ok := err.(ErrIdentity); ok {
+		return nil, err
+	} else if err != nil {
+		return nil, ErrEncoding{what: "server accept", cause: err}
+	}
+	_, err = conn.Write(serverAccept)
 	if err != nil {
-		return ErrProcessing{where: "sending server accept", cause: err}
+		return nil, ErrProcessing{where: "sending server accept", cause: err}
 	}
+	state.hooks.MessageSent(stage, len(serverAccept))
 
 	state.cleanSecrets()
-	return nil
+	return newResult(state)
+}
+
+// report tells the hooks how the handshake ended
+func (s *State) report(stage Stage, err error) {
+	if err != nil {
+		s.hooks.HandshakeFailed(stage, err)
+		return
+	}
+	remote := make(ed25519.PublicKey, len(s.remotePublic))
+	copy(remote, s.remotePublic)
+	s.hooks.HandshakeSucceeded(remote)
 }
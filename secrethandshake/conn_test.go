@@ -5,10 +5,10 @@
 package secrethandshake
 
 import (
+	"bytes"
 	"io"
 	"log"
 	"os"
-	"reflect"
 	"testing"
 )
 
@@ -63,17 +63,20 @@ func TestAuth(t *testing.T) {
 		t.Error("error making client state:", err)
 	}
 
-	// buffered channel
+	// buffered channels
 	ch := make(chan error, 2)
+	var srvRes, cliRes *Result
 
 	go func() {
-		err := Server(serverState, rwServer)
+		var err error
+		srvRes, err = Server(serverState, rwServer)
 		ch <- err
 		wServer.Close()
 	}()
 
 	go func() {
-		err := Client(clientState, rwClient)
+		var err error
+		cliRes, err = Client(clientState, rwClient)
 		ch <- err
 		wClient.Close()
 	}()
@@ -85,8 +88,35 @@ func TestAuth(t *testing.T) {
 	if err = <-ch; err != nil {
 		t.Errorf("2nd ch read: %v", err)
 	}
+	if srvRes == nil || cliRes == nil {
+		t.Fatal("handshake failed")
+	}
+
+	cliEnc, cliEncNonce := cliRes.BoxstreamEncKeys()
+	srvDec, srvDecNonce := srvRes.BoxstreamDecKeys()
+	if cliEnc != srvDec || cliEncNonce != srvDecNonce {
+		t.Error("client encryption keys don't match server decryption keys")
+	}
+	srvEnc, srvEncNonce := srvRes.BoxstreamEncKeys()
+	cliDec, cliDecNonce := cliRes.BoxstreamDecKeys()
+	if srvEnc != cliDec || srvEncNonce != cliDecNonce {
+		t.Error("server encryption keys don't match client decryption keys")
+	}
+
+	if clientState.secret != serverState.secret || clientState.secret != [32]byte{} {
+		t.Error("states not wiped")
+	}
+
+	if cliRes.SessionID() != srvRes.SessionID() {
+		t.Error("session ids not equal")
+	}
+	if !bytes.Equal(cliRes.Remote(), keySrv.Public) || !bytes.Equal(srvRes.Remote(), keyClient.Public) {
+		t.Error("wrong remote keys")
+	}
 
-	if !reflect.DeepEqual(clientState.secret, serverState.secret) {
-		t.Error("secrets not equal")
+	// results can't be modified through the returned key
+	cliRes.Remote()[0]++
+	if !bytes.Equal(cliRes.Remote(), keySrv.Public) {
+		t.Error("result modified through Remote")
 	}
 }
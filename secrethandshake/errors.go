@@ -7,10 +7,16 @@ package secrethandshake
 import (
 	"fmt"
 	"strconv"
+
+	"golang.org/x/crypto/ed25519"
 )
 
 var ErrInvalidKeyPair = fmt.Errorf("secrethandshake/NewKeyPair: invalid public key")
 
+// ErrInvalidRemoteKey is returned if the long-term public key of the remote party is of low order
+// or can't be converted to a curve25519 key.
+var ErrInvalidRemoteKey = fmt.Errorf("secrethandshake: invalid remote public key")
+
 type ErrKeySize struct {
 	tipe string
 	n    int
@@ -63,3 +69,31 @@ func (e ErrEncoding) Error() string {
 
 // Unwrap returns the cause
 func (e ErrEncoding) Unwrap() error { return e.cause }
+
+// ErrUnauthorized is returned by Server if the authorizer refused the client.
+type ErrUnauthorized struct {
+	// Remote is the key of the client, which was authenticated before it was refused.
+	Remote ed25519.PublicKey
+
+	cause error
+}
+
+func (e ErrUnauthorized) Error() string {
+	return "secrethandshake: client not authorized: " + e.cause.Error()
+}
+
+// Unwrap returns the error of the authorizer
+func (e ErrUnauthorized) Unwrap() error { return e.cause }
+
+// ErrIdentity is returned if the local Identity failed to sign or exchange keys.
+type ErrIdentity struct {
+	op    string
+	cause error
+}
+
+func (e ErrIdentity) Error() string {
+	return "secrethandshake: identity failed to " + e.op + ": " + e.cause.Error()
+}
+
+// Unwrap returns the error of the Identity
+func (e ErrIdentity) Unwrap() error { return e.cause }
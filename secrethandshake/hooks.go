@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"strconv"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Role is the side of the handshake a party plays.
+type Role int
+
+const (
+	RoleClient Role = iota
+	RoleServer
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleClient:
+		return "client"
+	case RoleServer:
+		return "server"
+	default:
+		return "role(" + strconv.Itoa(int(r)) + ")"
+	}
+}
+
+// Stage is a step of the handshake.
+type Stage int
+
+const (
+	StageSendChallenge Stage = iota
+	StageReceiveChallenge
+	StageVerifyChallenge
+	StageSendClientAuth
+	StageReceiveClientAuth
+	StageVerifyClientAuth
+	StageSendServerAccept
+	StageReceiveServerAccept
+	StageVerifyServerAccept
+)
+
+var stageNames = [...]string{
+	StageSendChallenge:       "send challenge",
+	StageReceiveChallenge:    "receive challenge",
+	StageVerifyChallenge:     "verify challenge",
+	StageSendClientAuth:      "send client auth",
+	StageReceiveClientAuth:   "receive client auth",
+	StageVerifyClientAuth:    "verify client auth",
+	StageSendServerAccept:    "send server accept",
+	StageReceiveServerAccept: "receive server accept",
+	StageVerifyServerAccept:  "verify server accept",
+}
+
+func (s Stage) String() string {
+	if s < 0 || int(s) >= len(stageNames) {
+		return "stage(" + strconv.Itoa(int(s)) + ")"
+	}
+	return stageNames[s]
+}
+
+// Hooks are notified about the progress of a handshake, for instance to
+// collect metrics or traces. They are called synchronously from Client and
+// Server, so they should return quickly.
+type Hooks interface {
+	// HandshakeStarted is called before the first message is exchanged.
+	HandshakeStarted(role Role)
+
+	// MessageSent is called after the message of a send stage was written.
+	MessageSent(stage Stage, n int)
+
+	// MessageReceived is called after the message of a receive stage was read.
+	MessageReceived(stage Stage, n int)
+
+	// HandshakeSucceeded is called with the authenticated long-term key of the remote.
+	HandshakeSucceeded(remote ed25519.PublicKey)
+
+	// HandshakeFailed is called with the stage that failed and the error Client or Server returns.
+	HandshakeFailed(stage Stage, err error)
+}
+
+// NopHooks ignores all events. It can be embedded to only implement some of the Hooks.
+type NopHooks struct{}
+
+var _ Hooks = NopHooks{}
+
+func (NopHooks) HandshakeStarted(Role)                {}
+func (NopHooks) MessageSent(Stage, int)               {}
+func (NopHooks) MessageReceived(Stage, int)           {}
+func (NopHooks) HandshakeSucceeded(ed25519.PublicKey) {}
+func (NopHooks) HandshakeFailed(Stage, error)         {}
+
+// multiHooks passes every event to all of its elements, in order
+type multiHooks []Hooks
+
+func (m multiHooks) HandshakeStarted(r Role) {
+	for _, h := range m {
+		h.HandshakeStarted(r)
+	}
+}
+
+func (m multiHooks) MessageSent(s Stage, n int) {
+	for _, h := range m {
+		h.MessageSent(s, n)
+	}
+}
+
+func (m multiHooks) MessageReceived(s Stage, n int) {
+	for _, h := range m {
+		h.MessageReceived(s, n)
+	}
+}
+
+func (m multiHooks) HandshakeSucceeded(remote ed25519.PublicKey) {
+	for _, h := range m {
+		h.HandshakeSucceeded(remote)
+	}
+}
+
+func (m multiHooks) HandshakeFailed(s Stage, err error) {
+	for _, h := range m {
+		h.HandshakeFailed(s, err)
+	}
+}
+
+// StateOption configures a State.
+type StateOption func(*State)
+
+// WithHooks makes the handshake report its progress to h. If it is passed
+// more than once, all Hooks are called in the order they were passed.
+func WithHooks(h Hooks) StateOption {
+	return func(s *State) {
+		if h != nil {
+			s.hooks = append(s.hooks, h)
+		}
+	}
+}
+
+// WithAuthorizer makes Server call authorize with the long-term key of the
+// client once the client proved that it owns it. If authorize returns an
+// error, the server doesn't accept the client and Server returns an
+// ErrUnauthorized wrapping it. Client ignores the authorizer.
+func WithAuthorizer(authorize func(remote ed25519.PublicKey) error) StateOption {
+	return func(s *State) {
+		s.authorize = authorize
+	}
+}
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// recordingHooks writes every event into a list
+type recordingHooks struct {
+	events []string
+	remote ed25519.PublicKey
+	err    error
+}
+
+func (h *recordingHooks) HandshakeStarted(r Role) {
+	h.events = append(h.events, "start "+r.String())
+}
+
+func (h *recordingHooks) MessageSent(s Stage, n int) {
+	h.events = append(h.events, fmt.Sprintf("%s %d", s, n))
+}
+
+func (h *recordingHooks) MessageReceived(s Stage, n int) {
+	h.events = append(h.events, fmt.Sprintf("%s %d", s, n))
+}
+
+func (h *recordingHooks) HandshakeSucceeded(remote ed25519.PublicKey) {
+	h.events = append(h.events, "success")
+	h.remote = remote
+}
+
+func (h *recordingHooks) HandshakeFailed(s Stage, err error) {
+	h.events = append(h.events, "failed "+s.String())
+	h.err = err
+}
+
+// shake runs a handshake between a client that expects remotePublic and a server using keySrv and srvOpts.
+func shake(t *testing.T, keySrv, keyClient *EdKeyPair, remotePublic ed25519.PublicKey, srvOpts ...StateOption) (srvHooks, cliHooks *recordingHooks) {
+	appKey := make([]byte, 32)
+	io.ReadFull(StupidRandom(255), appKey)
+
+	rServer, wClient := io.Pipe()
+	rClient, wServer := io.Pipe()
+
+	srvHooks, cliHooks = new(recordingHooks), new(recordingHooks)
+
+	serverState, err := NewServerState(appKey, *keySrv, append(srvOpts, WithHooks(srvHooks))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientState, err := NewClientState(appKey, *keyClient, remotePublic, WithHooks(cliHooks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		Server(serverState, rw{rServer, wServer})
+		wServer.Close()
+		rServer.Close()
+		done <- struct{}{}
+	}()
+	go func() {
+		Client(clientState, rw{rClient, wClient})
+		wClient.Close()
+		rClient.Close()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+
+	return srvHooks, cliHooks
+}
+
+func TestHooks(t *testing.T) {
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvHooks, cliHooks := shake(t, keySrv, keyClient, keySrv.Public)
+
+	wantClient := []string{
+		"start client",
+		"send challenge 64",
+		"receive challenge 64",
+		"send client auth 112",
+		"receive server accept 80",
+		"success",
+	}
+	if !reflect.DeepEqual(wantClient, cliHooks.events) {
+		t.Errorf("wrong client events: %q", cliHooks.events)
+	}
+	if !bytes.Equal(keySrv.Public, cliHooks.remote) {
+		t.Error("client got wrong remote key")
+	}
+
+	wantServer := []string{
+		"start server",
+		"receive challenge 64",
+		"send challenge 64",
+		"receive client auth 112",
+		"send server accept 80",
+		"success",
+	}
+	if !reflect.DeepEqual(wantServer, srvHooks.events) {
+		t.Errorf("wrong server events: %q", srvHooks.events)
+	}
+	if !bytes.Equal(keyClient.Public, srvHooks.remote) {
+		t.Error("server got wrong remote key")
+	}
+}
+
+func TestHooksFailure(t *testing.T) {
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client expects another server
+	keyOther, err := GenEdKeyPair(StupidRandom(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvHooks, cliHooks := shake(t, keySrv, keyClient, keyOther.Public)
+
+	if last := srvHooks.events[len(srvHooks.events)-1]; last != "failed verify client auth" {
+		t.Errorf("wrong last server event: %q", last)
+	}
+	if _, ok := srvHooks.err.(ErrProtocol); !ok {
+		t.Errorf("wrong server error: %v", srvHooks.err)
+	}
+
+	if last := cliHooks.events[len(cliHooks.events)-1]; last != "failed receive server accept" {
+		t.Errorf("wrong last client event: %q", last)
+	}
+	if _, ok := cliHooks.err.(ErrProcessing); !ok {
+		t.Errorf("wrong client error: %v", cliHooks.err)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var asked ed25519.PublicKey
+	allow := WithAuthorizer(func(remote ed25519.PublicKey) error {
+		asked = remote
+		return nil
+	})
+	srvHooks, cliHooks := shake(t, keySrv, keyClient, keySrv.Public, allow)
+	if srvHooks.err != nil || cliHooks.err != nil {
+		t.Fatal("handshake failed:", srvHooks.err, cliHooks.err)
+	}
+	if !bytes.Equal(keyClient.Public, asked) {
+		t.Error("authorizer got wrong remote key")
+	}
+
+	denied := errors.New("denied")
+	deny := WithAuthorizer(func(ed25519.PublicKey) error { return denied })
+	srvHooks, cliHooks = shake(t, keySrv, keyClient, keySrv.Public, deny)
+
+	if last := srvHooks.events[len(srvHooks.events)-1]; last != "failed verify client auth" {
+		t.Errorf("wrong last server event: %q", last)
+	}
+	if _, ok := srvHooks.err.(ErrUnauthorized); !ok || !errors.Is(srvHooks.err, denied) {
+		t.Errorf("wrong server error: %v", srvHooks.err)
+	}
+
+	// the client never gets the server accept
+	if last := cliHooks.events[len(cliHooks.events)-1]; last != "failed receive server accept" {
+		t.Errorf("wrong last client event: %q", last)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"github.com/ssbc/go-secretstream/secrethandshake/internal/extra25519"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Identity is the long-term key pair of a party. The handshake only uses the
+// private key through Sign and DH, so it can be kept outside of the process,
+// for instance in an ssh-agent. EdKeyPair is the in-memory implementation.
+type Identity interface {
+	// PublicKey returns the long-term ed25519 public key.
+	PublicKey() ed25519.PublicKey
+
+	// Sign returns the ed25519 signature of msg.
+	Sign(msg []byte) ([]byte, error)
+
+	// DH returns the curve25519 product of the private key, converted to
+	// curve25519, and the curve25519 point peer.
+	DH(peer *[32]byte) ([32]byte, error)
+}
+
+var _ Identity = EdKeyPair{}
+
+// PublicKey returns kp.Public.
+func (kp EdKeyPair) PublicKey() ed25519.PublicKey {
+	return kp.Public
+}
+
+// Sign signs msg with kp.Secret.
+func (kp EdKeyPair) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(kp.Secret, msg), nil
+}
+
+// DH multiplies peer with the curve25519 form of kp.Secret.
+func (kp EdKeyPair) DH(peer *[32]byte) ([32]byte, error) {
+	var curveSecret, shared [32]byte
+	extra25519.PrivateKeyToCurve25519(&curveSecret, kp.Secret)
+	curve25519.ScalarMult(&shared, &curveSecret, peer)
+	return shared, nil
+}
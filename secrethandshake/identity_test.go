@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// countingIdentity passes every operation to an EdKeyPair and counts them,
+// or fails them if err is set
+type countingIdentity struct {
+	EdKeyPair
+	signs, dhs int
+	err        error
+}
+
+func (id *countingIdentity) Sign(msg []byte) ([]byte, error) {
+	id.signs++
+	if id.err != nil {
+		return nil, id.err
+	}
+	return id.EdKeyPair.Sign(msg)
+}
+
+func (id *countingIdentity) DH(peer *[32]byte) ([32]byte, error) {
+	id.dhs++
+	if id.err != nil {
+		return [32]byte{}, id.err
+	}
+	return id.EdKeyPair.DH(peer)
+}
+
+// shakeIdentities runs a handshake between the two identities, with opts on
+// both sides, and returns the results of both sides
+func shakeIdentities(t *testing.T, srvID, cliID Identity, opts ...StateOption) (srvErr, cliErr error, srvRes, cliRes *Result) {
+	appKey := make([]byte, 32)
+
+	srvState, err := NewServerStateWithIdentity(appKey, srvID, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliState, err := NewClientStateWithIdentity(appKey, cliID, srvID.PublicKey(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rServer, wClient := io.Pipe()
+	rClient, wServer := io.Pipe()
+
+	srvErrc := make(chan error, 1)
+	go func() {
+		var err error
+		srvRes, err = Server(srvState, rw{rServer, wServer})
+		wServer.Close()
+		rServer.Close()
+		srvErrc <- err
+	}()
+
+	cliRes, cliErr = Client(cliState, rw{rClient, wClient})
+	wClient.Close()
+	rClient.Close()
+	srvErr = <-srvErrc
+	return srvErr, cliErr, srvRes, cliRes
+}
+
+func TestIdentity(t *testing.T) {
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvID, cliID := &countingIdentity{EdKeyPair: *keySrv}, &countingIdentity{EdKeyPair: *keyClient}
+	srvErr, cliErr, srvRes, cliRes := shakeIdentities(t, srvID, cliID)
+	if srvErr != nil || cliErr != nil {
+		t.Fatal("handshake failed:", srvErr, cliErr)
+	}
+	if srvRes.SessionID() != cliRes.SessionID() {
+		t.Fatal("session IDs differ")
+	}
+
+	// each side signs once and does one long-term key exchange
+	for _, id := range []*countingIdentity{srvID, cliID} {
+		if id.signs != 1 || id.dhs != 1 {
+			t.Errorf("unexpected use of the identity: %d signs, %d key exchanges", id.signs, id.dhs)
+		}
+	}
+}
+
+func TestIdentityFailure(t *testing.T) {
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agentGone := errors.New("agent gone")
+
+	// the client fails to sign its authentication
+	srvErr, cliErr, _, _ := shakeIdentities(t, *keySrv, &countingIdentity{EdKeyPair: *keyClient, err: agentGone})
+	if _, ok := cliErr.(ErrIdentity); !ok || !errors.Is(cliErr, agentGone) {
+		t.Error("wrong client error:", cliErr)
+	}
+	if srvErr == nil {
+		t.Error("expected the server to fail")
+	}
+
+	// the server fails to exchange keys
+	srvErr, cliErr, _, _ = shakeIdentities(t, &countingIdentity{EdKeyPair: *keySrv, err: agentGone}, *keyClient)
+	if _, ok := srvErr.(ErrIdentity); !ok || !errors.Is(srvErr, agentGone) {
+		t.Error("wrong server error:", srvErr)
+	}
+	if cliErr == nil {
+		t.Error("expected the client to fail")
+	}
+}
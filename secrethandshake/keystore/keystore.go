@@ -0,0 +1,368 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package keystore keeps secret-handshake identities encrypted on disk.
+//
+// Each identity is a file in the directory of the Store. Its private key is
+// sealed with secretbox, using a key that argon2id derives from a passphrase.
+// The public key is stored in the clear, so identities can be listed without
+// the passphrase.
+package keystore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Version is the version of the file format written by this package.
+const Version = 1
+
+const fileSuffix = ".key"
+
+var (
+	// ErrNotFound is returned if there is no identity with the name.
+	ErrNotFound = errors.New("keystore: identity not found")
+
+	// ErrExists is returned by Create if there already is an identity with the name.
+	ErrExists = errors.New("keystore: identity already exists")
+
+	// ErrWrongPassphrase is returned if the identity can't be decrypted with the passphrase.
+	ErrWrongPassphrase = errors.New("keystore: wrong passphrase")
+
+	// ErrInvalidName is returned for names that aren't usable as file names.
+	ErrInvalidName = errors.New("keystore: invalid name")
+
+	// ErrInvalidParams is returned for argon2id parameters that argon2 can't
+	// use or that exceed MaxMemory, either in a file or in Store.Params.
+	ErrInvalidParams = errors.New("keystore: invalid key derivation parameters")
+)
+
+// UnsupportedVersionError is returned for files written by a newer version of this package.
+type UnsupportedVersionError struct {
+	Version int
+}
+
+func (e UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("keystore: unsupported file version %d", e.Version)
+}
+
+// Params are the argon2id parameters used to derive the key from a passphrase.
+type Params struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+// DefaultParams follow the second recommended option of RFC 9106.
+var DefaultParams = Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// MaxMemory is the most memory, in KiB, that deriving a key may use. It
+// protects against files that would exhaust the memory of the process.
+const MaxMemory = 1024 * 1024
+
+// saltSize is the length of the salt of each file
+const saltSize = 16
+
+// check returns ErrInvalidParams unless p can be used to derive a key.
+func (p Params) check() error {
+	if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) || p.Memory > MaxMemory {
+		return fmt.Errorf("%w (time %d, memory %d KiB, threads %d)", ErrInvalidParams, p.Time, p.Memory, p.Threads)
+	}
+	return nil
+}
+
+// file is the JSON format of an identity
+type file struct {
+	Version int    `json:"version"`
+	Public  string `json:"public"`
+
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+
+	Nonce  []byte `json:"nonce"`
+	Sealed []byte `json:"sealed"`
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+
+// Store is a directory of encrypted identities.
+type Store struct {
+	dir string
+
+	// Params are used to encrypt new identities and on passphrase changes.
+	Params Params
+}
+
+// Open returns the Store in dir, creating the directory if it doesn't exist.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, Params: DefaultParams}, nil
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(s.dir, name+fileSuffix), nil
+}
+
+// List returns the names of all identities, sorted.
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), fileSuffix)
+		if e.Type().IsRegular() && name != e.Name() && validName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Create encrypts kp with passphrase and stores it as name.
+func (s *Store) Create(name string, kp secrethandshake.EdKeyPair, passphrase []byte) error {
+	if _, err := secrethandshake.NewKeyPair(kp.Public, kp.Secret); err != nil {
+		return err
+	}
+
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return ErrExists
+	}
+
+	f, err := seal(kp, passphrase, s.Params)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, f, false)
+}
+
+// Public returns the public key of name without decrypting it.
+func (s *Store) Public(name string) (ed25519.PublicKey, error) {
+	f, err := s.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return decodePublic(f.Public)
+}
+
+// Load decrypts name with passphrase.
+func (s *Store) Load(name string, passphrase []byte) (*secrethandshake.EdKeyPair, error) {
+	f, err := s.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return open(f, passphrase)
+}
+
+// ChangePassphrase encrypts name with newPassphrase, using the current Params.
+// The file is replaced atomically.
+func (s *Store) ChangePassphrase(name string, oldPassphrase, newPassphrase []byte) error {
+	f, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	kp, err := open(f, oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	resealed, err := seal(*kp, newPassphrase, s.Params)
+	if err != nil {
+		return err
+	}
+	path, _ := s.path(name)
+	return writeFile(path, resealed, true)
+}
+
+// Delete removes name from the store.
+func (s *Store) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *Store) read(name string) (*file, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// check the version first, newer versions may change everything else
+	var v struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("keystore: invalid file %s: %w", path, err)
+	}
+	if v.Version != Version {
+		return nil, UnsupportedVersionError{v.Version}
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("keystore: invalid file %s: %w", path, err)
+	}
+	if f.KDF != "argon2id" {
+		return nil, fmt.Errorf("keystore: unsupported kdf %q in %s", f.KDF, path)
+	}
+	return &f, nil
+}
+
+func deriveKey(passphrase, salt []byte, p Params) *[32]byte {
+	var key [32]byte
+	copy(key[:], argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Threads, 32))
+	return &key
+}
+
+func seal(kp secrethandshake.EdKeyPair, passphrase []byte, p Params) (*file, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	f := &file{
+		Version: Version,
+		Public:  base64.StdEncoding.EncodeToString(kp.Public) + ".ed25519",
+		KDF:     "argon2id",
+		Salt:    make([]byte, saltSize),
+		Time:    p.Time,
+		Memory:  p.Memory,
+		Threads: p.Threads,
+		Nonce:   make([]byte, 24),
+	}
+	if _, err := io.ReadFull(rand.Reader, f.Salt); err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	copy(f.Nonce, nonce[:])
+
+	key := deriveKey(passphrase, f.Salt, p)
+	f.Sealed = secretbox.Seal(nil, kp.Secret, &nonce, key)
+	return f, nil
+}
+
+func open(f *file, passphrase []byte) (*secrethandshake.EdKeyPair, error) {
+	if len(f.Nonce) != 24 {
+		return nil, fmt.Errorf("keystore: invalid nonce length %d", len(f.Nonce))
+	}
+	if len(f.Salt) != saltSize {
+		return nil, fmt.Errorf("keystore: invalid salt length %d", len(f.Salt))
+	}
+	p := Params{Time: f.Time, Memory: f.Memory, Threads: f.Threads}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], f.Nonce)
+
+	key := deriveKey(passphrase, f.Salt, p)
+	secret, ok := secretbox.Open(nil, f.Sealed, &nonce, key)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+
+	public, err := decodePublic(f.Public)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := secrethandshake.NewKeyPair(public, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(kp.Public, kp.Secret.Public().(ed25519.PublicKey)) {
+		return nil, errors.New("keystore: public key doesn't match the private key")
+	}
+	return kp, nil
+}
+
+func decodePublic(s string) (ed25519.PublicKey, error) {
+	if !strings.HasSuffix(s, ".ed25519") {
+		return nil, fmt.Errorf("keystore: invalid public key %q", s)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s, ".ed25519"))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("keystore: invalid public key %q", s)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// writeFile writes f to a temporary file and renames it to path. Unless
+// replace is true, it fails if path exists.
+func writeFile(path string, f *file, replace bool) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !replace {
+		// a hard link fails if path exists, unlike a rename
+		err = os.Link(tmp.Name(), path)
+		if os.IsExist(err) {
+			err = ErrExists
+		}
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
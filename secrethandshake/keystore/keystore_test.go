@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+)
+
+// fastParams keep the tests quick, they are far too weak for real use
+var fastParams = Params{Time: 1, Memory: 64, Threads: 1}
+
+func newTestStore(t *testing.T) (*Store, func()) {
+	dir, err := os.MkdirTemp("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Params = fastParams
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func genKeyPair(t *testing.T) *secrethandshake.EdKeyPair {
+	kp, err := secrethandshake.GenEdKeyPair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestStore(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	alice, bob := genKeyPair(t), genKeyPair(t)
+	if err := s.Create("alice", *alice, []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create("bob", *bob, []byte("battery staple")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create("alice", *bob, []byte("x")); err != ErrExists {
+		t.Fatal("expected ErrExists, got", err)
+	}
+
+	names, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+		t.Fatal("unexpected names:", names)
+	}
+
+	pub, err := s.Public("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pub, bob.Public) {
+		t.Fatal("wrong public key")
+	}
+
+	kp, err := s.Load("alice", []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kp.Public, alice.Public) || !bytes.Equal(kp.Secret, alice.Secret) {
+		t.Fatal("loaded wrong key pair")
+	}
+
+	if _, err := s.Load("alice", []byte("battery staple")); err != ErrWrongPassphrase {
+		t.Fatal("expected ErrWrongPassphrase, got", err)
+	}
+	if _, err := s.Load("carol", nil); err != ErrNotFound {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+	if _, err := s.Load("../alice", nil); err != ErrInvalidName {
+		t.Fatal("expected ErrInvalidName, got", err)
+	}
+
+	// the private key is not stored in the clear
+	data, err := os.ReadFile(filepath.Join(s.dir, "alice.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, alice.Secret[:32]) {
+		t.Fatal("found the private key in the file")
+	}
+
+	if err := s.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("bob"); err != ErrNotFound {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	alice := genKeyPair(t)
+	if err := s.Create("alice", *alice, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ChangePassphrase("alice", []byte("wrong"), []byte("new")); err != ErrWrongPassphrase {
+		t.Fatal("expected ErrWrongPassphrase, got", err)
+	}
+
+	s.Params = Params{Time: 2, Memory: 128, Threads: 1}
+	if err := s.ChangePassphrase("alice", []byte("old"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Load("alice", []byte("old")); err != ErrWrongPassphrase {
+		t.Fatal("old passphrase still works:", err)
+	}
+	kp, err := s.Load("alice", []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kp.Secret, alice.Secret) {
+		t.Fatal("loaded wrong key pair")
+	}
+
+	// the new parameters were used and no temporary files are left
+	f, err := s.read("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Time != 2 || f.Memory != 128 {
+		t.Fatal("parameters not updated:", f.Time, f.Memory)
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("unexpected files:", len(entries))
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := s.Create("alice", *genKeyPair(t), []byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(s.dir, "alice.key")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), `"version": 1`, `"version": 2`, 1))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Load("alice", []byte("pw"))
+	if v, ok := err.(UnsupportedVersionError); !ok || v.Version != 2 {
+		t.Fatal("expected UnsupportedVersionError, got", err)
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := s.Create("alice", *genKeyPair(t), []byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(s.dir, "alice.key")
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupted files must not crash or exhaust memory
+	for _, c := range []struct{ from, to string }{
+		{`"threads": 1`, `"threads": 0`},
+		{`"time": 1`, `"time": 0`},
+		{`"memory": 64`, `"memory": 4294967295`},
+	} {
+		data := []byte(strings.Replace(string(orig), c.from, c.to, 1))
+		if bytes.Equal(data, orig) {
+			t.Fatalf("%s not found in file", c.from)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Load("alice", []byte("pw")); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("%s: expected ErrInvalidParams, got %v", c.to, err)
+		}
+	}
+
+	data := []byte(strings.Replace(string(orig), `"salt": "`, `"salt": "AA`, 1))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load("alice", []byte("pw")); err == nil {
+		t.Error("expected error for a salt of the wrong length")
+	}
+
+	// and so must bad parameters of the store
+	s.Params = Params{Time: 1, Memory: 64, Threads: 0}
+	if err := s.Create("bob", *genKeyPair(t), []byte("pw")); !errors.Is(err, ErrInvalidParams) {
+		t.Error("expected ErrInvalidParams, got", err)
+	}
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/ssbc/go-secretstream/secrethandshake/internal/extra25519"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// precomputedKeyPair is an EdKeyPair with its private key already converted to curve25519
+type precomputedKeyPair struct {
+	EdKeyPair
+	curveSecret [32]byte
+}
+
+// NewPrecomputedIdentity returns an Identity for kp that converts the private
+// key to curve25519 once, instead of in every handshake.
+func NewPrecomputedIdentity(kp EdKeyPair) (Identity, error) {
+	if err := checkKeyPair(kp); err != nil {
+		return nil, err
+	}
+
+	p := &precomputedKeyPair{EdKeyPair: kp}
+	extra25519.PrivateKeyToCurve25519(&p.curveSecret, kp.Secret)
+	return p, nil
+}
+
+func (p *precomputedKeyPair) DH(peer *[32]byte) ([32]byte, error) {
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, &p.curveSecret, peer)
+	return shared, nil
+}
+
+// EphemeralKeyPool keeps ephemeral key pairs ready, so that handshakes don't
+// have to wait for their generation. Each key pair is only handed out once.
+// The pool refills itself in the background whenever a key pair was taken,
+// so it needs no goroutine while it is full.
+type EphemeralKeyPool struct {
+	keys chan CurveKeyPair
+
+	mu        sync.Mutex
+	refilling bool
+}
+
+// NewEphemeralKeyPool returns a pool of up to size key pairs and starts filling it.
+func NewEphemeralKeyPool(size int) *EphemeralKeyPool {
+	p := &EphemeralKeyPool{keys: make(chan CurveKeyPair, size)}
+	p.refill()
+	return p
+}
+
+// get returns a key pair from the pool, or a new one if it is empty.
+func (p *EphemeralKeyPool) get() (CurveKeyPair, error) {
+	defer p.refill()
+	select {
+	case kp := <-p.keys:
+		return kp, nil
+	default:
+		return generateEphemeral()
+	}
+}
+
+// refill starts filling the pool unless that is already happening
+func (p *EphemeralKeyPool) refill() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refilling || len(p.keys) == cap(p.keys) {
+		return
+	}
+	p.refilling = true
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.refilling = false
+			p.mu.Unlock()
+		}()
+
+		for {
+			kp, err := generateEphemeral()
+			if err != nil {
+				return
+			}
+			select {
+			case p.keys <- kp:
+			default:
+				// full
+				return
+			}
+		}
+	}()
+}
+
+func generateEphemeral() (CurveKeyPair, error) {
+	var kp CurveKeyPair
+	pub, sec, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return kp, err
+	}
+	kp.Public, kp.Secret = *pub, *sec
+	return kp, nil
+}
+
+// WithEphemeralKeyPool makes the handshake take its ephemeral key pair from p.
+func WithEphemeralKeyPool(p *EphemeralKeyPool) StateOption {
+	return func(s *State) {
+		s.ephemeral = p
+	}
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPrecomputedIdentity(t *testing.T) {
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvID, err := NewPrecomputedIdentity(*keySrv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := generateEphemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := keySrv.DH(&peer.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := srvID.DH(&peer.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatal("precomputed key exchange differs from the plain one")
+	}
+
+	// and it can talk to plain key pairs
+	srvErr, cliErr, srvRes, cliRes := shakeIdentities(t, srvID, *keyClient)
+	if srvErr != nil || cliErr != nil {
+		t.Fatal("handshake failed:", srvErr, cliErr)
+	}
+	if srvRes.SessionID() != cliRes.SessionID() {
+		t.Fatal("session IDs differ")
+	}
+
+	// broken key pairs are refused up front
+	if _, err := NewPrecomputedIdentity(EdKeyPair{Public: keySrv.Public, Secret: keySrv.Secret[:32]}); err == nil {
+		t.Fatal("expected short key to be refused")
+	}
+}
+
+func TestEphemeralKeyPool(t *testing.T) {
+	const size = 4
+	p := NewEphemeralKeyPool(size)
+
+	waitFull := func() {
+		deadline := time.Now().Add(time.Second)
+		for len(p.keys) < size {
+			if time.Now().After(deadline) {
+				t.Fatalf("pool not refilled: %d of %d keys", len(p.keys), size)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFull()
+
+	// more than the pool holds, so some are generated on the spot
+	seen := make(map[[32]byte]bool)
+	for i := 0; i < 3*size; i++ {
+		kp, err := p.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[kp.Public] {
+			t.Fatal("key pair handed out twice")
+		}
+		seen[kp.Public] = true
+	}
+	waitFull()
+}
+
+func BenchmarkHandshake(b *testing.B) {
+	keySrv, err := GenEdKeyPair(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	keyClient, err := GenEdKeyPair(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	precomputed, err := NewPrecomputedIdentity(*keySrv)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("plain", func(b *testing.B) {
+		benchmarkHandshake(b, *keySrv, *keyClient, nil)
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		benchmarkHandshake(b, precomputed, *keyClient, nil)
+	})
+	b.Run("precomputed+pool", func(b *testing.B) {
+		// the best case for the pool, every handshake starts with a full pool
+		benchmarkHandshake(b, precomputed, *keyClient, NewEphemeralKeyPool(1))
+	})
+}
+
+// benchmarkHandshake measures full handshakes with srvID, taking the server's
+// ephemeral keys from pool unless it is nil
+func benchmarkHandshake(b *testing.B, srvID Identity, keyClient EdKeyPair, pool *EphemeralKeyPool) {
+	appKey := make([]byte, 32)
+	var srvOpts []StateOption
+	if pool != nil {
+		srvOpts = append(srvOpts, WithEphemeralKeyPool(pool))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if pool != nil {
+			b.StopTimer()
+			for len(pool.keys) < cap(pool.keys) {
+				time.Sleep(10 * time.Microsecond)
+			}
+			b.StartTimer()
+		}
+
+		srvState, err := NewServerStateWithIdentity(appKey, srvID, srvOpts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cliState, err := NewClientState(appKey, keyClient, srvID.PublicKey())
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		rServer, wClient := io.Pipe()
+		rClient, wServer := io.Pipe()
+
+		srvErrc := make(chan error, 1)
+		go func() {
+			_, err := Server(srvState, rw{rServer, wServer})
+			srvErrc <- err
+		}()
+		if _, err := Client(cliState, rw{rClient, wClient}); err != nil {
+			b.Fatal(err)
+		}
+		if err := <-srvErrc; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
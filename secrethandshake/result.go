@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"crypto/sha256"
+	"unsafe"
+
+	"github.com/ssbc/go-secretstream/internal/securemem"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Result is the outcome of a successful handshake: the long-term key of the
+// remote and the keys and nonces for the boxstreams in both directions. It is
+// only returned by Client and Server, so it never holds the keys of an
+// unfinished handshake, and its methods return copies, so it can't be
+// modified. It is safe for concurrent use, except for Wipe.
+type Result struct {
+	remote    ed25519.PublicKey
+	sessionID [32]byte
+
+	keysBuf *securemem.Buffer
+	keys    *resultKeys
+}
+
+// resultKeys are the secret parts of a Result. They contain no pointers, so
+// they can be kept in locked memory, see WithSecureMemory.
+type resultKeys struct {
+	enKey, deKey     [32]byte
+	enNonce, deNonce [24]byte
+}
+
+// newResult derives the session keys from the state of a finished handshake.
+func newResult(s *State) (*Result, error) {
+	buf, err := securemem.Alloc(int(unsafe.Sizeof(resultKeys{})), s.secureMemory)
+	if err != nil {
+		return nil, err
+	}
+	keys := (*resultKeys)(unsafe.Pointer(&buf.Bytes()[0]))
+
+	h := sha256.New()
+	h.Write(s.secret[:])
+	h.Write(s.remotePublic[:])
+	copy(keys.enKey[:], h.Sum(nil))
+	copy(keys.enNonce[:], s.remoteAppMac)
+
+	h.Reset()
+	h.Write(s.secret[:])
+	h.Write(s.localPublic[:])
+	copy(keys.deKey[:], h.Sum(nil))
+	copy(keys.deNonce[:], s.localAppMac[:])
+
+	return &Result{
+		remote:    append(ed25519.PublicKey(nil), s.remotePublic...),
+		sessionID: sha256.Sum256(s.secret[:]),
+
+		keysBuf: buf,
+		keys:    keys,
+	}, nil
+}
+
+// Remote returns the long-term public key of the remote party.
+func (r *Result) Remote() ed25519.PublicKey {
+	return append(ed25519.PublicKey(nil), r.remote...)
+}
+
+// SessionID returns an identifier of the session that is the same for both parties.
+// It is derived from the shared secret but doesn't reveal it.
+func (r *Result) SessionID() [32]byte {
+	return r.sessionID
+}
+
+// BoxstreamEncKeys returns the encryption key and nonce suitable for boxstream.
+func (r *Result) BoxstreamEncKeys() ([32]byte, [24]byte) {
+	return r.keys.enKey, r.keys.enNonce
+}
+
+// BoxstreamDecKeys returns the decryption key and nonce suitable for boxstream.
+func (r *Result) BoxstreamDecKeys() ([32]byte, [24]byte) {
+	return r.keys.deKey, r.keys.deNonce
+}
+
+// Wipe overwrites the session keys and releases their locked memory, once
+// they were handed to a boxstream.Boxer and Unboxer. The key methods return
+// zeros afterwards. Calls after the first one do nothing.
+func (r *Result) Wipe() {
+	if r.keysBuf == nil {
+		return
+	}
+	r.keysBuf.Free()
+	r.keysBuf = nil
+	r.keys = new(resultKeys)
+}
@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/ssbc/go-secretstream/internal/securemem"
+	"github.com/ssbc/go-secretstream/secrethandshake/internal/extra25519"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrIdentityClosed is returned by the methods of a LockedIdentity after Close.
+var ErrIdentityClosed = errors.New("secrethandshake: identity was closed")
+
+// WithSecureMemory keeps the secrets of the handshake in memory that is
+// locked, so it is never written to swap, and wiped by State.Wipe. It is only
+// supported on Linux. Ephemeral keys from an EphemeralKeyPool wait for their
+// handshake in ordinary memory.
+func WithSecureMemory() StateOption {
+	return func(s *State) {
+		s.secureMemory = true
+	}
+}
+
+// lockedKeys is the layout of the locked memory of a LockedIdentity
+type lockedKeys struct {
+	secret      [ed25519.PrivateKeySize]byte
+	curveSecret [32]byte
+}
+
+// LockedIdentity is an Identity that keeps a copy of the private key, and its
+// curve25519 form, in locked memory until Close. It is safe for concurrent use.
+//
+// Built with Go 1.23 or older, Sign uses the locked key directly. Since Go
+// 1.24, crypto/ed25519 caches the expanded form of every key it signs with,
+// keyed by a weak pointer that the runtime only allows into the Go heap. With
+// these versions, Sign copies the key to ordinary memory for the call and
+// wipes the copy afterwards, while the expanded key stays in the cache until
+// the garbage collector removes it. Values derived from the key inside
+// crypto/ed25519 are never wiped.
+type LockedIdentity struct {
+	public ed25519.PublicKey
+
+	mu   sync.RWMutex
+	buf  *securemem.Buffer // nil after Close
+	keys *lockedKeys
+}
+
+var _ Identity = (*LockedIdentity)(nil)
+
+// NewLockedIdentity copies kp into locked memory. It is only supported on
+// Linux. Callers should overwrite kp.Secret once they no longer need it.
+func NewLockedIdentity(kp EdKeyPair) (*LockedIdentity, error) {
+	if err := checkKeyPair(kp); err != nil {
+		return nil, err
+	}
+
+	buf, err := securemem.Alloc(int(unsafe.Sizeof(lockedKeys{})), true)
+	if err != nil {
+		return nil, err
+	}
+	keys := (*lockedKeys)(unsafe.Pointer(&buf.Bytes()[0]))
+	copy(keys.secret[:], kp.Secret)
+	extra25519.PrivateKeyToCurve25519(&keys.curveSecret, kp.Secret)
+
+	return &LockedIdentity{
+		public: append(ed25519.PublicKey(nil), kp.Public...),
+		buf:    buf,
+		keys:   keys,
+	}, nil
+}
+
+// PublicKey returns the long-term public key.
+func (id *LockedIdentity) PublicKey() ed25519.PublicKey {
+	return id.public
+}
+
+// Sign signs msg with the private key.
+func (id *LockedIdentity) Sign(msg []byte) ([]byte, error) {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	if id.buf == nil {
+		return nil, ErrIdentityClosed
+	}
+	return signLocked(id.keys.secret[:], msg), nil
+}
+
+// DH multiplies peer with the curve25519 form of the private key.
+func (id *LockedIdentity) DH(peer *[32]byte) ([32]byte, error) {
+	var shared [32]byte
+
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	if id.buf == nil {
+		return shared, ErrIdentityClosed
+	}
+	curve25519.ScalarMult(&shared, &id.keys.curveSecret, peer)
+	return shared, nil
+}
+
+// Close wipes the private key. Handshakes using the identity fail afterwards.
+func (id *LockedIdentity) Close() error {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.buf != nil {
+		id.buf.Free()
+		id.buf, id.keys = nil, nil
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !go1.24
+// +build !go1.24
+
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import "golang.org/x/crypto/ed25519"
+
+// signLocked signs msg with a key in locked memory.
+func signLocked(key, msg []byte) []byte {
+	return ed25519.Sign(ed25519.PrivateKey(key), msg)
+}
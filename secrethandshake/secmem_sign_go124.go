@@ -0,0 +1,24 @@
+//go:build go1.24
+// +build go1.24
+
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"github.com/ssbc/go-secretstream/internal/securemem"
+	"golang.org/x/crypto/ed25519"
+)
+
+// signLocked signs msg with a key in locked memory. Since Go 1.24,
+// crypto/ed25519 caches expanded keys by a weak pointer to the key, and the
+// runtime aborts the process for weak pointers outside of the Go heap, so the
+// key is copied to the heap for the call.
+func signLocked(key, msg []byte) []byte {
+	tmp := make(ed25519.PrivateKey, len(key))
+	copy(tmp, key)
+	defer securemem.Wipe(tmp)
+	return ed25519.Sign(tmp, msg)
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ssbc/go-secretstream/internal/securemem"
+)
+
+func TestStateWipe(t *testing.T) {
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts []StateOption
+	if securemem.Supported() {
+		opts = append(opts, WithSecureMemory())
+	}
+	srvErr, cliErr, srvRes, cliRes := shakeIdentities(t, *keySrv, *keyClient, opts...)
+	if srvErr != nil || cliErr != nil {
+		t.Fatal("handshake failed:", srvErr, cliErr)
+	}
+
+	srvKey, _ := srvRes.BoxstreamEncKeys()
+	cliKey, _ := cliRes.BoxstreamDecKeys()
+	if srvKey != cliKey {
+		t.Fatal("session keys differ")
+	}
+
+	keys := srvRes.keys
+	srvRes.Wipe()
+	srvRes.Wipe()
+	if *keys != (resultKeys{}) || *srvRes.keys != (resultKeys{}) {
+		t.Error("session keys not wiped")
+	}
+	if srvRes.SessionID() != cliRes.SessionID() {
+		t.Error("session ID lost by Wipe")
+	}
+
+	// a state that is never used
+	s, err := NewServerState(make([]byte, 32), *keySrv, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.createChallenge()
+	sec := s.secrets
+	if *sec == (secrets{}) {
+		t.Fatal("no ephemeral key")
+	}
+	s.Wipe()
+	s.Wipe()
+	if *sec != (secrets{}) || *s.secrets != (secrets{}) {
+		t.Error("secrets not wiped")
+	}
+	if s.appKey != [32]byte{} || s.localAppMac != [32]byte{} {
+		t.Error("challenge not wiped")
+	}
+}
+
+func TestLockedIdentity(t *testing.T) {
+	if !securemem.Supported() {
+		t.Skip("locked memory not supported")
+	}
+
+	keySrv, err := GenEdKeyPair(StupidRandom(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyClient, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvID, err := NewLockedIdentity(*keySrv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("the same signature as crypto/ed25519")
+	sig, err := srvID.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := keySrv.Sign(msg); !bytes.Equal(sig, want) {
+		t.Fatal("signatures differ")
+	}
+
+	srvErr, cliErr, _, _ := shakeIdentities(t, srvID, *keyClient)
+	if srvErr != nil || cliErr != nil {
+		t.Fatal("handshake failed:", srvErr, cliErr)
+	}
+
+	if err := srvID.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srvID.Sign([]byte("msg")); err != ErrIdentityClosed {
+		t.Error("expected ErrIdentityClosed from Sign, got", err)
+	}
+	var peer [32]byte
+	if _, err := srvID.DH(&peer); err != ErrIdentityClosed {
+		t.Error("expected ErrIdentityClosed from DH, got", err)
+	}
+}
+
+func TestBadRemoteKeyFreesMemory(t *testing.T) {
+	if !securemem.Supported() {
+		t.Skip("no locked memory on this platform")
+	}
+	kp, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lowOrder := make([]byte, 32)
+	lowOrder[0] = 1
+
+	before := securemem.InUse()
+	for _, remote := range [][]byte{lowOrder, lowOrder[:16]} {
+		if _, err := NewClientState(make([]byte, 32), *kp, remote, WithSecureMemory()); err == nil {
+			t.Fatal("expected bad remote key to be refused")
+		}
+	}
+	if n := securemem.InUse(); n != before {
+		t.Fatalf("%d locked buffers leaked", n-before)
+	}
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrInsecureSecretFile is returned by LoadSSBSecret if other users may access the file.
+var ErrInsecureSecretFile = fmt.Errorf("secrethandshake: secret file is accessible by other users")
+
+// ssbSecret is the JSON object in an SSB secret file
+type ssbSecret struct {
+	Curve   string `json:"curve"`
+	Public  string `json:"public"`
+	Private string `json:"private"`
+	ID      string `json:"id,omitempty"`
+}
+
+// ParseSSBSecret parses the contents of an SSB secret file, as found in
+// ~/.ssb/secret. Lines starting with # are ignored, the rest is a JSON
+// object with the curve, public and private fields. The key pair is checked
+// to be consistent and of a usable public key.
+func ParseSSBSecret(data []byte) (*EdKeyPair, error) {
+	var js bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if strings.HasPrefix(strings.TrimSpace(s.Text()), "#") {
+			continue
+		}
+		js.Write(s.Bytes())
+		js.WriteByte('\n')
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	var sec ssbSecret
+	if err := json.Unmarshal(js.Bytes(), &sec); err != nil {
+		return nil, fmt.Errorf("secrethandshake: invalid secret: %w", err)
+	}
+	if sec.Curve != "ed25519" {
+		return nil, fmt.Errorf("secrethandshake: unsupported curve %q in secret", sec.Curve)
+	}
+
+	public, err := decodeSSBKey(sec.Public)
+	if err != nil {
+		return nil, fmt.Errorf("secrethandshake: invalid public key in secret: %w", err)
+	}
+	private, err := decodeSSBKey(sec.Private)
+	if err != nil {
+		return nil, fmt.Errorf("secrethandshake: invalid private key in secret: %w", err)
+	}
+
+	kp, err := NewKeyPair(public, private)
+	if err != nil {
+		return nil, err
+	}
+
+	// the private key carries its public key, they have to agree
+	if !bytes.Equal(kp.Secret.Public().(ed25519.PublicKey), kp.Public) {
+		return nil, fmt.Errorf("secrethandshake: public key doesn't match private key in secret")
+	}
+	if sec.ID != "" && sec.ID != "@"+sec.Public {
+		return nil, fmt.Errorf("secrethandshake: id %q doesn't match public key in secret", sec.ID)
+	}
+	return kp, nil
+}
+
+// decodeSSBKey decodes a key in the base64.ed25519 format
+func decodeSSBKey(s string) ([]byte, error) {
+	if !strings.HasSuffix(s, ".ed25519") {
+		return nil, fmt.Errorf("missing .ed25519 suffix")
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSuffix(s, ".ed25519"))
+}
+
+// MarshalSSBSecret returns kp in the format of an SSB secret file.
+func MarshalSSBSecret(kp EdKeyPair) ([]byte, error) {
+	if _, err := NewKeyPair(kp.Public, kp.Secret); err != nil {
+		return nil, err
+	}
+
+	public := base64.StdEncoding.EncodeToString(kp.Public) + ".ed25519"
+	js, err := json.MarshalIndent(ssbSecret{
+		Curve:   "ed25519",
+		Public:  public,
+		Private: base64.StdEncoding.EncodeToString(kp.Secret) + ".ed25519",
+		ID:      "@" + public,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# this is your SECRET name.\n")
+	buf.WriteString("# this name gives you magical powers.\n")
+	buf.WriteString("# with it you can mark your messages so that your friends can verify\n")
+	buf.WriteString("# that they really did come from you.\n")
+	buf.WriteString("#\n")
+	buf.WriteString("# if any one learns this name, they can use it to destroy your identity\n")
+	buf.WriteString("# NEVER show this to anyone!!!\n\n")
+	buf.Write(js)
+	buf.WriteString("\n\n# WARNING! It's vital that you DO NOT edit OR share your secret name\n")
+	buf.WriteString("# instead, share your public name\n")
+	buf.WriteString("# your public name: @" + public + "\n")
+	return buf.Bytes(), nil
+}
+
+// LoadSSBSecret reads an SSB secret file. On systems other than Windows, it
+// returns ErrInsecureSecretFile if the file can be accessed by users other
+// than its owner.
+func LoadSSBSecret(path string) (*EdKeyPair, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return nil, ErrInsecureSecretFile
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSSBSecret(data)
+}
+
+// SaveSSBSecret writes kp to a new SSB secret file at path that only its
+// owner can access. It never replaces an existing file, to avoid losing an
+// identity by accident.
+func SaveSSBSecret(path string, kp EdKeyPair) error {
+	data, err := MarshalSSBSecret(kp)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// an identity as written by ssb-keys
+const aliceSecret = `# this is your SECRET name.
+# NEVER show this to anyone!!!
+
+{
+  "curve": "ed25519",
+  "public": "reZVj+irIL9Iev8xDh9R2jiPzgkM3QOYB158oxt2TT8=.ed25519",
+  "private": "XRCnlde+L/QoBpLawuwVRqk6biMeHY5P4fOgA4G1Du+t5lWP6Ksgv0h6/zEOH1HaOI/OCQzdA5gHXnyjG3ZNPw==.ed25519",
+  "id": "@reZVj+irIL9Iev8xDh9R2jiPzgkM3QOYB158oxt2TT8=.ed25519"
+}
+
+# your public name: @reZVj+irIL9Iev8xDh9R2jiPzgkM3QOYB158oxt2TT8=.ed25519
+`
+
+func TestParseSSBSecret(t *testing.T) {
+	kp, err := ParseSSBSecret([]byte(aliceSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := mustLoadTestKeyPair(t, "key.alice.json")
+	if !bytes.Equal(kp.Public, alice.Public) || !bytes.Equal(kp.Secret, alice.Secret) {
+		t.Fatal("parsed wrong key pair")
+	}
+
+	data, err := MarshalSSBSecret(*kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseSSBSecret(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Public, kp.Public) || !bytes.Equal(again.Secret, kp.Secret) {
+		t.Fatal("round trip changed the key pair")
+	}
+}
+
+func TestParseSSBSecretInvalid(t *testing.T) {
+	bob := mustLoadTestKeyPair(t, "key.bob.json")
+	bobData, err := MarshalSSBSecret(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobPublic := strings.SplitN(string(bobData), `"public": "`, 2)[1][:52]
+
+	for name, data := range map[string]string{
+		"not json":  "# only a comment\n",
+		"curve":     strings.Replace(aliceSecret, `"ed25519"`, `"k256"`, 1),
+		"suffix":    strings.Replace(aliceSecret, `=.ed25519",`+"\n  \"private\"", `=",`+"\n  \"private\"", 1),
+		"mismatch":  strings.Replace(aliceSecret, `"public": "reZVj+irIL9Iev8xDh9R2jiPzgkM3QOYB158oxt2TT8=.ed25519"`, `"public": "`+bobPublic+`"`, 1),
+		"id":        strings.Replace(aliceSecret, `"id": "@reZVj`, `"id": "@aaZVj`, 1),
+		"low order": strings.Replace(aliceSecret, "reZVj+irIL9Iev8xDh9R2jiPzgkM3QOYB158oxt2TT8=.ed25519\",\n  \"private", "AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=.ed25519\",\n  \"private", 1),
+		"short key": strings.Replace(aliceSecret, `"private": "XRCnlde+`, `"private": "`, 1),
+	} {
+		if _, err := ParseSSBSecret([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSSBSecretFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secret")
+
+	kp, err := GenEdKeyPair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveSSBSecret(path, *kp); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveSSBSecret(path, *kp); !os.IsExist(err) {
+		t.Fatal("expected existing file to be kept, got", err)
+	}
+
+	loaded, err := LoadSSBSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Public, kp.Public) || !bytes.Equal(loaded.Secret, kp.Secret) {
+		t.Fatal("loaded wrong key pair")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSSBSecret(path); err != ErrInsecureSecretFile {
+		t.Fatal("expected ErrInsecureSecretFile, got", err)
+	}
+}
@@ -44,7 +44,7 @@ func TestServer(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := Server(serverState, client); err != nil {
+	if _, err := Server(serverState, client); err != nil {
 		t.Fatal(err)
 	}
 }
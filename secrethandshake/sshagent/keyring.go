@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sshagent
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// keyring is the in-memory keyring of the agent package with support for DHExtension
+type keyring struct {
+	agent.ExtendedAgent
+
+	mu   sync.Mutex
+	keys map[string]ed25519.PrivateKey // by ssh wire encoding of the public key
+}
+
+// NewKeyring returns an in-memory agent like agent.NewKeyring, which also
+// supports DHExtension for the ed25519 keys added to it.
+func NewKeyring() agent.ExtendedAgent {
+	return &keyring{
+		ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent),
+		keys:          make(map[string]ed25519.PrivateKey),
+	}
+}
+
+func (r *keyring) Add(key agent.AddedKey) error {
+	if err := r.ExtendedAgent.Add(key); err != nil {
+		return err
+	}
+
+	var priv ed25519.PrivateKey
+	switch k := key.PrivateKey.(type) {
+	case ed25519.PrivateKey:
+		priv = k
+	case *ed25519.PrivateKey:
+		priv = *k
+	default:
+		return nil
+	}
+
+	sshPub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.keys[string(sshPub.Marshal())] = append(ed25519.PrivateKey(nil), priv...)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *keyring) Remove(key ssh.PublicKey) error {
+	r.mu.Lock()
+	delete(r.keys, string(key.Marshal()))
+	r.mu.Unlock()
+	return r.ExtendedAgent.Remove(key)
+}
+
+func (r *keyring) RemoveAll() error {
+	r.mu.Lock()
+	r.keys = make(map[string]ed25519.PrivateKey)
+	r.mu.Unlock()
+	return r.ExtendedAgent.RemoveAll()
+}
+
+// Extension implements DHExtension.
+func (r *keyring) Extension(extensionType string, contents []byte) ([]byte, error) {
+	if extensionType != DHExtension {
+		return nil, agent.ErrExtensionUnsupported
+	}
+
+	var req dhRequest
+	if err := ssh.Unmarshal(contents, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Peer) != 32 {
+		return nil, errors.New("sshagent: invalid peer key length")
+	}
+
+	// the keyring hides removed keys and all keys while it is locked
+	listed, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	var found bool
+	for _, k := range listed {
+		if bytes.Equal(k.Marshal(), req.Key) {
+			found = true
+			break
+		}
+	}
+
+	r.mu.Lock()
+	priv, ok := r.keys[string(req.Key)]
+	r.mu.Unlock()
+	if !found || !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	var peer [32]byte
+	copy(peer[:], req.Peer)
+	kp := secrethandshake.EdKeyPair{Public: priv.Public().(ed25519.PublicKey), Secret: priv}
+	shared, err := kp.DH(&peer)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{agentSuccess}, ssh.Marshal(dhResponse{Shared: shared[:]})...), nil
+}
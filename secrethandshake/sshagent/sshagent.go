@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package sshagent keeps the long-term key of a secret-handshake identity in
+// an ssh-agent.
+//
+// The handshake needs two operations with the private key: an ed25519
+// signature, which every ssh-agent supports, and a curve25519 key exchange
+// with the key converted to curve25519, which isn't part of the agent
+// protocol. The key exchange is requested with the agent extension
+// DHExtension. OpenSSH's ssh-agent doesn't support it, so an agent that does,
+// like the one returned by NewKeyring, has to hold the key. It can run in a
+// separate process and be served with agent.ServeAgent.
+package sshagent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// DHExtension is the name of the agent extension for the key exchange. Its
+// request holds the ssh wire encoding of the public key and the 32 byte
+// curve25519 point of the peer, as two strings. A successful response starts
+// with SSH_AGENT_SUCCESS, followed by the 32 byte product as a string.
+const DHExtension = "curve25519-dh@ssbc.github.io"
+
+// agentSuccess is SSH_AGENT_SUCCESS
+const agentSuccess = 6
+
+// ErrKeyNotFound is returned by NewIdentity if the agent doesn't hold the key.
+var ErrKeyNotFound = errors.New("sshagent: key not found in agent")
+
+type dhRequest struct {
+	Key  []byte
+	Peer []byte
+}
+
+type dhResponse struct {
+	Shared []byte
+}
+
+// Identity is a secrethandshake.Identity whose private key is held by an agent.
+type Identity struct {
+	agent  agent.ExtendedAgent
+	public ed25519.PublicKey
+	sshPub ssh.PublicKey
+}
+
+var _ secrethandshake.Identity = (*Identity)(nil)
+
+// NewIdentity returns the Identity of public in a. The agent has to support
+// DHExtension for the handshake to succeed, see the package documentation.
+// Use agent.NewClient to connect to an agent over a socket.
+func NewIdentity(a agent.ExtendedAgent, public ed25519.PublicKey) (*Identity, error) {
+	sshPub, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), sshPub.Marshal()) {
+			return &Identity{
+				agent:  a,
+				public: append(ed25519.PublicKey(nil), public...),
+				sshPub: sshPub,
+			}, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// PublicKey returns the public key of the identity.
+func (id *Identity) PublicKey() ed25519.PublicKey {
+	return id.public
+}
+
+// Sign asks the agent to sign msg.
+func (id *Identity) Sign(msg []byte) ([]byte, error) {
+	sig, err := id.agent.Sign(id.sshPub, msg)
+	if err != nil {
+		return nil, err
+	}
+	if sig.Format != ssh.KeyAlgoED25519 || len(sig.Blob) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("sshagent: unexpected signature format %q", sig.Format)
+	}
+	return sig.Blob, nil
+}
+
+// DH asks the agent for the key exchange with peer, using DHExtension. If the
+// agent doesn't support it, the error is agent.ErrExtensionUnsupported.
+func (id *Identity) DH(peer *[32]byte) ([32]byte, error) {
+	var shared [32]byte
+
+	res, err := id.agent.Extension(DHExtension, ssh.Marshal(dhRequest{
+		Key:  id.sshPub.Marshal(),
+		Peer: peer[:],
+	}))
+	if err != nil {
+		return shared, err
+	}
+
+	var dh dhResponse
+	if len(res) == 0 || res[0] != agentSuccess {
+		return shared, errors.New("sshagent: unexpected key exchange response")
+	}
+	if err := ssh.Unmarshal(res[1:], &dh); err != nil {
+		return shared, fmt.Errorf("sshagent: invalid key exchange response: %w", err)
+	}
+	if len(dh.Shared) != len(shared) {
+		return shared, errors.New("sshagent: invalid key exchange response length")
+	}
+	copy(shared[:], dh.Shared)
+	return shared, nil
+}
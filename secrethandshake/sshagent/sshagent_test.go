@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package sshagent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serve connects to a over a pipe, so the requests go through the agent protocol
+func serve(a agent.Agent) (agent.ExtendedAgent, func() error) {
+	c1, c2 := net.Pipe()
+	go agent.ServeAgent(a, c2)
+	return agent.NewClient(c1), c1.Close
+}
+
+func addKey(t *testing.T, a agent.Agent) *secrethandshake.EdKeyPair {
+	kp, err := secrethandshake.GenEdKeyPair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Add(agent.AddedKey{PrivateKey: kp.Secret}); err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+type rw struct {
+	io.Reader
+	io.Writer
+}
+
+// shake runs a handshake and returns the errors of both sides
+func shake(t *testing.T, srvID, cliID secrethandshake.Identity) (srvErr, cliErr error) {
+	appKey := make([]byte, 32)
+
+	srvState, err := secrethandshake.NewServerStateWithIdentity(appKey, srvID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliState, err := secrethandshake.NewClientStateWithIdentity(appKey, cliID, srvID.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rServer, wClient := io.Pipe()
+	rClient, wServer := io.Pipe()
+
+	var srvRes *secrethandshake.Result
+	srvErrc := make(chan error, 1)
+	go func() {
+		var err error
+		srvRes, err = secrethandshake.Server(srvState, rw{rServer, wServer})
+		wServer.Close()
+		rServer.Close()
+		srvErrc <- err
+	}()
+
+	cliRes, cliErr := secrethandshake.Client(cliState, rw{rClient, wClient})
+	wClient.Close()
+	rClient.Close()
+	srvErr = <-srvErrc
+
+	if srvErr == nil && cliErr == nil && srvRes.SessionID() != cliRes.SessionID() {
+		t.Fatal("session IDs differ")
+	}
+	return srvErr, cliErr
+}
+
+func TestAgentIdentity(t *testing.T) {
+	keyring := NewKeyring()
+	a, closeAgent := serve(keyring)
+	defer closeAgent()
+	srvKeys, cliKeys := addKey(t, keyring), addKey(t, keyring)
+
+	srvID, err := NewIdentity(a, srvKeys.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliID, err := NewIdentity(a, cliKeys.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both sides in the agent
+	if srvErr, cliErr := shake(t, srvID, cliID); srvErr != nil || cliErr != nil {
+		t.Fatal("handshake failed:", srvErr, cliErr)
+	}
+
+	// one side in memory
+	if srvErr, cliErr := shake(t, *srvKeys, cliID); srvErr != nil || cliErr != nil {
+		t.Fatal("handshake failed:", srvErr, cliErr)
+	}
+	if srvErr, cliErr := shake(t, srvID, *cliKeys); srvErr != nil || cliErr != nil {
+		t.Fatal("handshake failed:", srvErr, cliErr)
+	}
+
+	// the agent agrees with the in-memory key pair
+	var peer [32]byte
+	peer[0] = 9
+	want, _ := srvKeys.DH(&peer)
+	got, err := srvID.DH(&peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatal("agent computed a different shared secret")
+	}
+
+	// a locked agent refuses to exchange keys
+	if err := keyring.Lock([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srvID.DH(&peer); err == nil {
+		t.Fatal("expected a locked agent to fail")
+	}
+	if err := keyring.Unlock([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewIdentity(a, srvKeys.Public); err != ErrKeyNotFound {
+		t.Fatal("expected ErrKeyNotFound, got", err)
+	}
+}
+
+func TestPlainAgent(t *testing.T) {
+	// agent.NewKeyring can sign but doesn't know the extension
+	keyring := agent.NewKeyring()
+	a, closeAgent := serve(keyring)
+	defer closeAgent()
+	srvKeys := addKey(t, keyring)
+	cliKeys, err := secrethandshake.GenEdKeyPair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvID, err := NewIdentity(a, srvKeys.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvErr, cliErr := shake(t, srvID, *cliKeys)
+	if !errors.Is(srvErr, agent.ErrExtensionUnsupported) {
+		t.Fatal("expected ErrExtensionUnsupported, got", srvErr)
+	}
+	if cliErr == nil {
+		t.Fatal("expected the client to fail")
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+// Package sshkey converts between OpenSSH ed25519 private key files and
+// secret-handshake key pairs.
+package sshkey
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNotEd25519 is returned by ParsePrivateKey for keys of other types.
+var ErrNotEd25519 = errors.New("sshkey: not an ed25519 key")
+
+// ParsePrivateKey parses an OpenSSH private key file, as written by
+// ssh-keygen -t ed25519. If the key is encrypted, passphrase is used to
+// decrypt it; an empty passphrase then results in a *ssh.PassphraseMissingError.
+func ParsePrivateKey(pemBytes, passphrase []byte) (*secrethandshake.EdKeyPair, error) {
+	var (
+		raw interface{}
+		err error
+	)
+	if len(passphrase) == 0 {
+		raw, err = ssh.ParseRawPrivateKey(pemBytes)
+	} else {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var priv ed25519.PrivateKey
+	switch k := raw.(type) {
+	case *ed25519.PrivateKey:
+		priv = *k
+	case ed25519.PrivateKey:
+		priv = k
+	default:
+		return nil, ErrNotEd25519
+	}
+
+	// the file stores the public key next to the seed, make sure they belong together
+	public := ed25519.NewKeyFromSeed(priv.Seed()).Public().(ed25519.PublicKey)
+	if !bytes.Equal(public, priv[ed25519.SeedSize:]) {
+		return nil, errors.New("sshkey: public key doesn't match the private key")
+	}
+
+	return secrethandshake.NewKeyPair(public, priv)
+}
+
+// MarshalPrivateKey returns kp as an OpenSSH private key file with comment.
+// If passphrase is not empty, the key is encrypted with it.
+func MarshalPrivateKey(kp secrethandshake.EdKeyPair, comment string, passphrase []byte) ([]byte, error) {
+	if _, err := secrethandshake.NewKeyPair(kp.Public, kp.Secret); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(kp.Public, kp.Secret.Public().(ed25519.PublicKey)) {
+		return nil, errors.New("sshkey: public key doesn't match the private key")
+	}
+
+	var (
+		block *pem.Block
+		err   error
+	)
+	if len(passphrase) == 0 {
+		block, err = ssh.MarshalPrivateKey(kp.Secret, comment)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(kp.Secret, comment, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sshkey: failed to marshal key: %w", err)
+	}
+	return pem.EncodeToMemory(block), nil
+}
@@ -13,10 +13,11 @@ package secrethandshake
 
 import (
 	"bytes"
-	"crypto/rand"
 	"crypto/sha256"
+	"unsafe"
 
 	"github.com/ssbc/go-secretstream/internal/lo25519"
+	"github.com/ssbc/go-secretstream/internal/securemem"
 	"github.com/ssbc/go-secretstream/secrethandshake/internal/extra25519"
 	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/ed25519"
@@ -28,19 +29,33 @@ import (
 type State struct {
 	appKey [32]byte
 
-	secHash      []byte
 	localAppMac  [32]byte
 	remoteAppMac []byte
 
-	localExchange  CurveKeyPair
-	local          EdKeyPair
+	local          Identity
+	localPublic    ed25519.PublicKey // long-term
 	remoteExchange CurveKeyPair
 	remotePublic   ed25519.PublicKey // long-term
 
-	secret, secret2, secret3 [32]byte
+	*secrets
+	secretsBuf *securemem.Buffer
 
 	hello []byte
 
+	hooks        multiHooks
+	authorize    func(ed25519.PublicKey) error // server only, may be nil
+	ephemeral    *EphemeralKeyPool             // may be nil
+	secureMemory bool
+}
+
+// secrets are the parts of State that must not leak. They contain no
+// pointers, so they can be kept in locked memory, see WithSecureMemory.
+type secrets struct {
+	localExchange CurveKeyPair
+
+	secHash                  [32]byte
+	secret, secret2, secret3 [32]byte
+
 	aBob, bAlice [32]byte // better name? helloAlice, helloBob?
 }
 
@@ -76,48 +91,102 @@ type CurveKeyPair struct {
 }
 
 // NewClientState initializes the state for the client side
-func NewClientState(appKey []byte, local EdKeyPair, remotePublic ed25519.PublicKey) (*State, error) {
-	state, err := newState(appKey, local)
-	if err != nil {
-		return state, err
-	}
+func NewClientState(appKey []byte, local EdKeyPair, remotePublic ed25519.PublicKey, opts ...StateOption) (*State, error) {
+	return NewClientStateWithIdentity(appKey, local, remotePublic, opts...)
+}
 
-	state.remotePublic = remotePublic
-	if l := len(state.remotePublic); l != ed25519.PublicKeySize {
+// NewClientStateWithIdentity initializes the state for the client side, using
+// an Identity whose private key may be held elsewhere.
+func NewClientStateWithIdentity(appKey []byte, local Identity, remotePublic ed25519.PublicKey, opts ...StateOption) (*State, error) {
+	// check the remote key before newState allocates secrets
+	if l := len(remotePublic); l != ed25519.PublicKeySize {
 		return nil, ErrKeySize{tipe: "remote/public", n: l}
 	}
+	var curveRemotePubKey [32]byte
+	if !extra25519.PublicKeyToCurve25519(&curveRemotePubKey, remotePublic) {
+		return nil, ErrInvalidRemoteKey
+	}
 
-	return state, err
+	state, err := newState(appKey, local, opts)
+	if err != nil {
+		return nil, err
+	}
+	state.remotePublic = remotePublic
+	return state, nil
 }
 
 // NewServerState initializes the state for the server side
-func NewServerState(appKey []byte, local EdKeyPair) (*State, error) {
-	return newState(appKey, local)
+func NewServerState(appKey []byte, local EdKeyPair, opts ...StateOption) (*State, error) {
+	return NewServerStateWithIdentity(appKey, local, opts...)
+}
+
+// NewServerStateWithIdentity initializes the state for the server side, using
+// an Identity whose private key may be held elsewhere.
+func NewServerStateWithIdentity(appKey []byte, local Identity, opts ...StateOption) (*State, error) {
+	return newState(appKey, local, opts)
+}
+
+// checkKeyPair checks the sizes of the keys in kp
+func checkKeyPair(kp EdKeyPair) error {
+	if l := len(kp.Public); l != ed25519.PublicKeySize {
+		return ErrKeySize{tipe: "eph/public", n: l}
+	}
+
+	if l := len(kp.Secret); l != ed25519.PrivateKeySize {
+		return ErrKeySize{tipe: "eph/private", n: l}
+	}
+	return nil
 }
 
 // newState initializes the state needed by both client and server
-func newState(appKey []byte, local EdKeyPair) (*State, error) {
-	pubKey, secKey, err := box.GenerateKey(rand.Reader)
-	if err != nil {
-		return nil, err
+func newState(appKey []byte, local Identity, opts []StateOption) (*State, error) {
+	switch kp := local.(type) {
+	case EdKeyPair:
+		if err := checkKeyPair(kp); err != nil {
+			return nil, err
+		}
+	case *EdKeyPair:
+		if err := checkKeyPair(*kp); err != nil {
+			return nil, err
+		}
 	}
 
 	s := State{
 		remotePublic: make([]byte, ed25519.PublicKeySize),
 	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	var err error
+	s.secretsBuf, err = securemem.Alloc(int(unsafe.Sizeof(secrets{})), s.secureMemory)
+	if err != nil {
+		return nil, err
+	}
+	s.secrets = (*secrets)(unsafe.Pointer(&s.secretsBuf.Bytes()[0]))
+
+	var eph CurveKeyPair
+	if s.ephemeral != nil {
+		eph, err = s.ephemeral.get()
+	} else {
+		eph, err = generateEphemeral()
+	}
+	if err != nil {
+		s.Wipe()
+		return nil, err
+	}
+	s.localExchange = eph
+	eph = CurveKeyPair{}
+
 	copy(s.appKey[:], appKey)
-	copy(s.localExchange.Public[:], pubKey[:])
-	copy(s.localExchange.Secret[:], secKey[:])
 	s.local = local
+	s.localPublic = local.PublicKey()
 
-	if l := len(s.local.Public); l != ed25519.PublicKeySize {
+	if l := len(s.localPublic); l != ed25519.PublicKeySize {
+		s.Wipe()
 		return nil, ErrKeySize{tipe: "eph/public", n: l}
 	}
 
-	if l := len(s.local.Secret); l != ed25519.PrivateKeySize {
-		return nil, ErrKeySize{tipe: "eph/private", n: l}
-	}
-
 	return &s, nil
 }
 
@@ -145,16 +214,16 @@ func (s *State) verifyChallenge(ch []byte) bool {
 
 	secHasher := sha256.New()
 	secHasher.Write(s.secret[:])
-	s.secHash = secHasher.Sum(nil)
+	copy(s.secHash[:], secHasher.Sum(nil))
 
 	return ok
 }
 
 // createClientAuth returns a buffer containing a clientAuth message
-func (s *State) createClientAuth() []byte {
+func (s *State) createClientAuth() ([]byte, error) {
 	var curveRemotePubKey [32]byte
 	if !extra25519.PublicKeyToCurve25519(&curveRemotePubKey, s.remotePublic) {
-		panic("secrethandshake: could not convert remote to curve key")
+		return nil, ErrInvalidRemoteKey
 	}
 	var aBob [32]byte
 	curve25519.ScalarMult(&aBob, &s.localExchange.Secret, &curveRemotePubKey)
@@ -169,28 +238,33 @@ func (s *State) createClientAuth() []byte {
 	var sigMsg bytes.Buffer
 	sigMsg.Write(s.appKey[:])
 	sigMsg.Write(s.remotePublic[:])
-	sigMsg.Write(s.secHash)
+	sigMsg.Write(s.secHash[:])
 
-	sig := ed25519.Sign(s.local.Secret, sigMsg.Bytes())
+	sig, err := s.local.Sign(sigMsg.Bytes())
+	if err != nil {
+		return nil, ErrIdentity{op: "sign", cause: err}
+	}
 
 	var helloBuf bytes.Buffer
 	helloBuf.Write(sig[:])
-	helloBuf.Write(s.local.Public[:])
+	helloBuf.Write(s.localPublic[:])
 	s.hello = helloBuf.Bytes()
 
 	out := make([]byte, 0, len(s.hello)-box.Overhead)
 	var n [24]byte
 	out = box.SealAfterPrecomputation(out, s.hello, &n, &s.secret2)
-	return out
+	return out, nil
 }
 
 var nullHello [ed25519.SignatureSize + ed25519.PublicKeySize]byte
 
-// verifyClientAuth returns whether a buffer contains a valid clientAuth message
-func (s *State) verifyClientAuth(data []byte) bool {
-	var cvSec, aBob [32]byte
-	extra25519.PrivateKeyToCurve25519(&cvSec, s.local.Secret)
-	curve25519.ScalarMult(&aBob, &cvSec, &s.remoteExchange.Public)
+// verifyClientAuth returns whether a buffer contains a valid clientAuth message.
+// It only fails if the local Identity does.
+func (s *State) verifyClientAuth(data []byte) (bool, error) {
+	aBob, err := s.local.DH(&s.remoteExchange.Public)
+	if err != nil {
+		return false, ErrIdentity{op: "exchange keys", cause: err}
+	}
 	copy(s.aBob[:], aBob[:])
 
 	secHasher := sha256.New()
@@ -231,19 +305,19 @@ func (s *State) verifyClientAuth(data []byte) bool {
 
 	var sigMsg bytes.Buffer
 	sigMsg.Write(s.appKey[:])
-	sigMsg.Write(s.local.Public[:])
-	sigMsg.Write(s.secHash)
+	sigMsg.Write(s.localPublic[:])
+	sigMsg.Write(s.secHash[:])
 	verifyOk := ed25519.Verify(public, sigMsg.Bytes(), sig)
 
 	copy(s.remotePublic, public)
-	return openOk && verifyOk
+	return openOk && verifyOk, nil
 }
 
 // createServerAccept returns a buffer containing a serverAccept message
-func (s *State) createServerAccept() []byte {
+func (s *State) createServerAccept() ([]byte, error) {
 	var curveRemotePubKey [32]byte
 	if !extra25519.PublicKeyToCurve25519(&curveRemotePubKey, s.remotePublic) {
-		panic("secrethandshake: could not convert remote to curve key")
+		return nil, ErrInvalidRemoteKey
 	}
 	var bAlice [32]byte
 	curve25519.ScalarMult(&bAlice, &s.localExchange.Secret, &curveRemotePubKey)
@@ -259,21 +333,25 @@ func (s *State) createServerAccept() []byte {
 	var sigMsg bytes.Buffer
 	sigMsg.Write(s.appKey[:])
 	sigMsg.Write(s.hello[:])
-	sigMsg.Write(s.secHash)
+	sigMsg.Write(s.secHash[:])
 
-	okay := ed25519.Sign(s.local.Secret, sigMsg.Bytes())
+	okay, err := s.local.Sign(sigMsg.Bytes())
+	if err != nil {
+		return nil, ErrIdentity{op: "sign", cause: err}
+	}
 
 	var out = make([]byte, 0, len(okay)+16)
 	var nonce [24]byte // always 0?
-	return box.SealAfterPrecomputation(out, okay[:], &nonce, &s.secret3)
+	return box.SealAfterPrecomputation(out, okay[:], &nonce, &s.secret3), nil
 }
 
-// verifyServerAccept returns whether the passed buffer contains a valid serverAccept message
-func (s *State) verifyServerAccept(boxedOkay []byte) bool {
-	var curveLocalSec [32]byte
-	extra25519.PrivateKeyToCurve25519(&curveLocalSec, s.local.Secret)
-	var bAlice [32]byte
-	curve25519.ScalarMult(&bAlice, &curveLocalSec, &s.remoteExchange.Public)
+// verifyServerAccept returns whether the passed buffer contains a valid serverAccept message.
+// It only fails if the local Identity does.
+func (s *State) verifyServerAccept(boxedOkay []byte) (bool, error) {
+	bAlice, err := s.local.DH(&s.remoteExchange.Public)
+	if err != nil {
+		return false, ErrIdentity{op: "exchange keys", cause: err}
+	}
 	copy(s.bAlice[:], bAlice[:])
 
 	secHasher := sha256.New()
@@ -290,17 +368,17 @@ func (s *State) verifyServerAccept(boxedOkay []byte) bool {
 	var sigMsg bytes.Buffer
 	sigMsg.Write(s.appKey[:])
 	sigMsg.Write(s.hello[:])
-	sigMsg.Write(s.secHash)
+	sigMsg.Write(s.secHash[:])
 
 	verifyOk := ed25519.Verify(s.remotePublic, sigMsg.Bytes(), sig)
-	return verifyOk && openOk
+	return verifyOk && openOk, nil
 }
 
 // cleanSecrets overwrites all intermediate secrets and copies the final secret to s.secret
 func (s *State) cleanSecrets() {
 	var zeros [64]byte
 
-	copy(s.secHash, zeros[:])
+	copy(s.secHash[:], zeros[:])
 	copy(s.secret[:], zeros[:]) // redundant
 	copy(s.aBob[:], zeros[:])
 	copy(s.bAlice[:], zeros[:])
@@ -313,37 +391,21 @@ func (s *State) cleanSecrets() {
 	copy(s.localExchange.Secret[:], zeros[:])
 }
 
-// Remote returns the public key of the remote party
-func (s *State) Remote() []byte {
-	return s.remotePublic[:]
-}
-
-// GetBoxstreamEncKeys returns the encryption key and nonce suitable for boxstream
-func (s *State) GetBoxstreamEncKeys() ([32]byte, [24]byte) {
-	// TODO: error before cleanSecrets() has been called?
-
-	var enKey [32]byte
-	h := sha256.New()
-	h.Write(s.secret[:])
-	h.Write(s.remotePublic[:])
-	copy(enKey[:], h.Sum(nil))
-
-	var nonce [24]byte
-	copy(nonce[:], s.remoteAppMac)
-	return enKey, nonce
-}
-
-// GetBoxstreamDecKeys returns the decryption key and nonce suitable for boxstream
-func (s *State) GetBoxstreamDecKeys() ([32]byte, [24]byte) {
-	// TODO: error before cleanSecrets() has been called?
-
-	var deKey [32]byte
-	h := sha256.New()
-	h.Write(s.secret[:])
-	h.Write(s.local.Public[:])
-	copy(deKey[:], h.Sum(nil))
-
-	var nonce [24]byte
-	copy(nonce[:], s.localAppMac[:])
-	return deKey, nonce
+// Wipe overwrites all secrets held by s and releases its locked memory. Client
+// and Server do this when they return, so it is only needed for states that
+// are never used. s can't be used afterwards and Wipe must not be called while
+// a handshake is running on it.
+func (s *State) Wipe() {
+	if s.secretsBuf == nil {
+		return
+	}
+	s.secretsBuf.Free()
+	s.secretsBuf = nil
+	s.secrets = new(secrets)
+
+	s.appKey = [32]byte{}
+	s.localAppMac = [32]byte{}
+	securemem.Wipe(s.remoteAppMac)
+	securemem.Wipe(s.hello)
+	s.remoteAppMac, s.hello = nil, nil
 }
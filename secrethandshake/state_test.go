@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secrethandshake
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClientStateInvalidRemote(t *testing.T) {
+	appKey := make([]byte, 32)
+
+	kp, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the identity element is of low order
+	lowOrder := make([]byte, 32)
+	lowOrder[0] = 1
+
+	_, err = NewClientState(appKey, *kp, lowOrder)
+	if !errors.Is(err, ErrInvalidRemoteKey) {
+		t.Error("expected ErrInvalidRemoteKey, got:", err)
+	}
+
+	_, err = NewClientState(appKey, *kp, lowOrder[:16])
+	if _, ok := err.(ErrKeySize); !ok {
+		t.Error("expected ErrKeySize, got:", err)
+	}
+}
+
+func TestCreateMessagesInvalidRemote(t *testing.T) {
+	appKey := make([]byte, 32)
+
+	kp, err := GenEdKeyPair(StupidRandom(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewServerState(appKey, *kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as if a client authenticated with a low order key
+	copy(state.remotePublic, make([]byte, 32))
+
+	if _, err := state.createServerAccept(); !errors.Is(err, ErrInvalidRemoteKey) {
+		t.Error("createServerAccept: expected ErrInvalidRemoteKey, got:", err)
+	}
+
+	if _, err := state.createClientAuth(); !errors.Is(err, ErrInvalidRemoteKey) {
+		t.Error("createClientAuth: expected ErrInvalidRemoteKey, got:", err)
+	}
+}
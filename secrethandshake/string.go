@@ -24,7 +24,7 @@ func (s *State) String() string {
 	buf.Write(appKeyHex)
 
 	secHashHex := make([]byte, 2*len(s.secHash))
-	hex.Encode(secHashHex, s.secHash)
+	hex.Encode(secHashHex, s.secHash[:])
 	buf.WriteString("\n\tsecHash: ")
 	buf.Write(secHashHex)
 
@@ -43,8 +43,8 @@ func (s *State) String() string {
 	buf.WriteString("\n\tsecret3: ")
 	buf.Write(secret3Hex)
 
-	localPublicHex := make([]byte, 2*len(s.local.Public))
-	hex.Encode(localPublicHex, s.local.Public[:])
+	localPublicHex := make([]byte, 2*len(s.localPublic))
+	hex.Encode(localPublicHex, s.localPublic[:])
 	buf.WriteString("\n\tlocalPublic: ")
 	buf.Write(localPublicHex)
 
@@ -36,14 +36,14 @@ func main() {
 	s, err := secrethandshake.NewClientState(appKey, *keyPair, remotePublic)
 	check(err)
 
-	err = secrethandshake.Client(s, rw{os.Stdin, os.Stdout})
+	res, err := secrethandshake.Client(s, rw{os.Stdin, os.Stdout})
 	check(err)
 
-	encKey, encNonce := s.GetBoxstreamEncKeys()
+	encKey, encNonce := res.BoxstreamEncKeys()
 	os.Stdout.Write(encKey[:])
 	os.Stdout.Write(encNonce[:])
 
-	decKey, decNonnce := s.GetBoxstreamDecKeys()
+	decKey, decNonnce := res.BoxstreamDecKeys()
 	os.Stdout.Write(decKey[:])
 	os.Stdout.Write(decNonnce[:])
 }
@@ -40,14 +40,14 @@ func main() {
 	s, err := secrethandshake.NewServerState(appKey, keyPair)
 	check(err)
 
-	err = secrethandshake.Server(s, rw{os.Stdin, os.Stdout})
+	res, err := secrethandshake.Server(s, rw{os.Stdin, os.Stdout})
 	check(err)
 
-	encKey, encNonce := s.GetBoxstreamEncKeys()
+	encKey, encNonce := res.BoxstreamEncKeys()
 	os.Stdout.Write(encKey[:])
 	os.Stdout.Write(encNonce[:])
 
-	decKey, decNonnce := s.GetBoxstreamDecKeys()
+	decKey, decNonnce := res.BoxstreamDecKeys()
 	os.Stdout.Write(decKey[:])
 	os.Stdout.Write(decNonnce[:])
 }
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ssbc/go-secretstream/internal/securemem"
+	"github.com/ssbc/go-secretstream/secrethandshake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecureMemory(t *testing.T) {
+	if !securemem.Supported() {
+		t.Skip("locked memory not supported")
+	}
+	r := require.New(t)
+
+	opts := []Option{WithSecureMemory()}
+	srv, cli := mkConnPair(t, opts, append(opts, WithReadAhead(2)))
+
+	_, err := cli.Write([]byte("hello"))
+	r.NoError(err)
+	_, err = io.ReadFull(srv, make([]byte, 5))
+	r.NoError(err)
+
+	keys := cli.keys.Bytes()
+	r.NoError(cli.Close())
+	r.Nil(cli.keys.Bytes())
+	r.Equal(make([]byte, len(keys)), keys, "session keys not wiped")
+
+	_, err = cli.Write([]byte("again"))
+	r.True(errors.Is(err, net.ErrClosed), "unexpected error: %v", err)
+	_, err = cli.Read(make([]byte, 1))
+	r.True(errors.Is(err, net.ErrClosed), "unexpected error: %v", err)
+
+	// the remote got a goodbye and wipes its keys as well
+	_, err = srv.Read(make([]byte, 1))
+	r.Equal(io.EOF, err)
+	r.NoError(srv.Close())
+	r.Nil(srv.keys.Bytes())
+}
+
+func TestWipeOnClose(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+	keys := cli.keys.Bytes()
+	r.NoError(cli.Close())
+	r.Equal(make([]byte, len(keys)), keys, "session keys not wiped")
+
+	err := cli.WriteMessage([]byte("hello"))
+	r.True(errors.Is(err, net.ErrClosed), "unexpected error: %v", err)
+	r.NoError(srv.Close())
+}
+
+func TestSecureMemoryIdentity(t *testing.T) {
+	if !securemem.Supported() {
+		t.Skip("locked memory not supported")
+	}
+	r := require.New(t)
+
+	s, err := NewServer(*serverKeys, appKey, WithSecureMemory())
+	r.NoError(err)
+	r.NotNil(s.locked)
+	r.NoError(s.Shutdown(context.Background()))
+	_, err = s.locked.Sign([]byte("msg"))
+	r.Equal(secrethandshake.ErrIdentityClosed, err)
+
+	c, err := NewClient(*clientKeys, appKey, WithSecureMemory())
+	r.NoError(err)
+	r.NotNil(c.locked)
+	r.NoError(c.Close())
+	_, err = c.locked.Sign([]byte("msg"))
+	r.Equal(secrethandshake.ErrIdentityClosed, err)
+
+	// identities passed in stay with the caller
+	c, err = NewClientWithIdentity(*clientKeys, appKey, WithSecureMemory())
+	r.NoError(err)
+	r.Nil(c.locked)
+	r.NoError(c.Close())
+}
@@ -5,11 +5,10 @@
 package secretstream
 
 import (
-	"fmt"
 	"net"
+	"sync"
 	"time"
 
-	"github.com/ssbc/go-secretstream/boxstream"
 	"github.com/ssbc/go-secretstream/secrethandshake"
 
 	"github.com/ssbc/go-netwrap"
@@ -17,64 +16,110 @@ import (
 
 // Server can create net.Listeners
 type Server struct {
-	keyPair secrethandshake.EdKeyPair
-	appKey  []byte
+	id     secrethandshake.Identity
+	appKey []byte
+	opts   options
+
+	locked *secrethandshake.LockedIdentity // owned copy of the private key, may be nil
+
+	// state for Shutdown
+	mu         sync.Mutex
+	shutdown   bool
+	listeners  map[net.Listener]struct{}
+	handshakes map[net.Conn]struct{}
+	conns      map[*Conn]struct{}
 }
 
-// NewServer returns a Server which uses the passed keyPair and appKey
-func NewServer(keyPair secrethandshake.EdKeyPair, appKey []byte) (*Server, error) {
-	return &Server{keyPair: keyPair, appKey: appKey}, nil
+// NewServer returns a Server which uses the passed keyPair and appKey.
+// The private key is converted to curve25519 once, for all handshakes.
+func NewServer(keyPair secrethandshake.EdKeyPair, appKey []byte, opts ...Option) (*Server, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	id, locked, err := o.identity(keyPair)
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(id, appKey, o)
+	s.locked = locked
+	return s, nil
 }
 
-// ListenerWrapper returns a listener wrapper.
-func (s *Server) ListenerWrapper() netwrap.ListenerWrapper {
-	return netwrap.NewListenerWrapper(s.Addr(), s.ConnWrapper())
+// NewServerWithIdentity returns a Server that authenticates with id, whose
+// private key may be held elsewhere, for instance in an ssh-agent.
+func NewServerWithIdentity(id secrethandshake.Identity, appKey []byte, opts ...Option) (*Server, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(id, appKey, o), nil
 }
 
-// ConnWrapper returns a connection wrapper.
-func (s *Server) ConnWrapper() netwrap.ConnWrapper {
-	return func(conn net.Conn) (net.Conn, error) {
-		state, err := secrethandshake.NewServerState(s.appKey, s.keyPair)
-		if err != nil {
-			return nil, err
-		}
+func newServer(id secrethandshake.Identity, appKey []byte, o options) *Server {
+	s := &Server{
+		id:     id,
+		appKey: appKey,
+		opts:   o,
+
+		listeners:  make(map[net.Listener]struct{}),
+		handshakes: make(map[net.Conn]struct{}),
+		conns:      make(map[*Conn]struct{}),
+	}
+	s.opts.trackers = append(s.opts.trackers, s)
+	return s
+}
 
+// ListenerWrapper returns a listener wrapper. The wrapped listeners are
+// closed by Shutdown.
+func (s *Server) ListenerWrapper() netwrap.ListenerWrapper {
+	wrap := netwrap.NewListenerWrapper(s.Addr(), s.ConnWrapper())
+	return func(l net.Listener) (net.Listener, error) {
+		if !s.addListener(l) {
+			return nil, ErrServerClosed
+		}
+		wrapped, err := wrap(l)
 		if err != nil {
+			s.removeListener(l)
 			return nil, err
 		}
+		return &trackedListener{Listener: wrapped, s: s, orig: l}, nil
+	}
+}
 
-		errc := make(chan error)
-		go func() {
-			errc <- secrethandshake.Server(state, conn)
-			close(errc)
-		}()
-
-		select {
-		case err := <-errc:
-			if err != nil {
-				return nil, err
-			}
-		case <-time.After(2 * time.Minute):
-			return nil, fmt.Errorf("secretstream: handshake timeout")
+// ConnWrapper returns a connection wrapper.
+func (s *Server) ConnWrapper() netwrap.ConnWrapper {
+	return func(conn net.Conn) (net.Conn, error) {
+		if !s.startHandshake(conn) {
+			conn.Close()
+			return nil, ErrServerClosed
 		}
+		defer s.endHandshake(conn)
 
-		enKey, enNonce := state.GetBoxstreamEncKeys()
-		deKey, deNonce := state.GetBoxstreamDecKeys()
+		hs := handshake{
+			role:    secrethandshake.RoleServer,
+			appKey:  s.appKey,
+			local:   s.id.PublicKey(),
+			timeout: 2 * time.Minute,
+			opts:    s.opts,
 
-		remote := state.Remote()
-		boxed := &Conn{
-			boxer:   boxstream.NewBoxer(conn, &enNonce, &enKey),
-			unboxer: boxstream.NewUnboxer(conn, &deNonce, &deKey),
-			conn:    conn,
-			local:   s.keyPair.Public[:],
-			remote:  remote[:],
+			newState: func(opts ...secrethandshake.StateOption) (*secrethandshake.State, error) {
+				return secrethandshake.NewServerStateWithIdentity(s.appKey, s.id, opts...)
+			},
 		}
 
+		boxed, err := hs.run(conn)
+		if err != nil {
+			if s.isShutdown() {
+				return nil, ErrServerClosed
+			}
+			return nil, err
+		}
 		return boxed, nil
 	}
 }
 
 // Addr returns the shs-bs address of the server.
 func (s *Server) Addr() net.Addr {
-	return Addr{s.keyPair.Public[:]}
+	return Addr{s.id.PublicKey()}
 }
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by the wrappers of a Server after Shutdown was called.
+var ErrServerClosed = errors.New("secretstream: server closed")
+
+// Shutdown gracefully shuts down the server. It closes all listeners created
+// with ListenerWrapper, aborts handshakes that are in progress and sends a
+// goodbye to every connection the server accepted, so that the remotes read
+// io.EOF. Writing to these connections fails afterwards, but the application
+// can still read what the remotes send. Shutdown then waits until every
+// connection was closed by the application or its remote said goodbye, in
+// which case Shutdown closes it. The goodbye of a remote is only noticed when
+// the connection is read from, by the application or with WithReadAhead.
+// Connections still open when ctx is done are closed and ctx.Err() is
+// returned.
+//
+// Once Shutdown was called, the wrappers of the server return ErrServerClosed.
+// The copy of the private key that NewServer keeps with WithSecureMemory is
+// wiped before Shutdown returns.
+func (s *Server) Shutdown(ctx context.Context) error {
+	defer s.wipeIdentity()
+
+	s.mu.Lock()
+	s.shutdown = true
+	listeners := s.listeners
+	handshakes := s.handshakes
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.listeners = make(map[net.Listener]struct{})
+	s.handshakes = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	var firstErr error
+	for l := range listeners {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for conn := range handshakes {
+		conn.Close()
+	}
+
+	// a goodbye may wait for a slow remote, so say it to all of them at once
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *Conn) {
+			defer wg.Done()
+			c.closeWrite()
+			select {
+			case <-c.remoteEOF:
+				c.Close()
+			case <-c.done:
+			}
+		}(c)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return firstErr
+	case <-ctx.Done():
+	}
+
+	for _, c := range conns {
+		// closing the network connection makes a goodbye in progress give up
+		c.abort(nil)
+	}
+	<-drained
+	return ctx.Err()
+}
+
+func (s *Server) wipeIdentity() {
+	if s.locked != nil {
+		s.locked.Close()
+	}
+}
+
+func (s *Server) isShutdown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+func (s *Server) addListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) removeListener(l net.Listener) {
+	s.mu.Lock()
+	delete(s.listeners, l)
+	s.mu.Unlock()
+}
+
+// trackedListener forgets the listener it wraps once it is closed, so Shutdown
+// doesn't keep closed listeners around.
+type trackedListener struct {
+	net.Listener
+	s    *Server
+	orig net.Listener // as passed to addListener
+}
+
+func (l *trackedListener) Close() error {
+	l.s.removeListener(l.orig)
+	return l.Listener.Close()
+}
+
+// startHandshake registers a connection that is about to shake hands, so
+// Shutdown can abort it. It returns false if the server is shut down.
+func (s *Server) startHandshake(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return false
+	}
+	s.handshakes[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) endHandshake(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.handshakes, conn)
+	s.mu.Unlock()
+}
+
+// track keeps conn until it is closed, so Shutdown can say goodbye.
+func (s *Server) track(conn *Conn) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	conn.onClose(func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	})
+	return nil
+}
@@ -0,0 +1,206 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ssbc/go-secretstream/boxstream"
+
+	"github.com/ssbc/go-netwrap"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerShutdown(t *testing.T) {
+	r := require.New(t)
+
+	s, err := NewServer(*serverKeys, appKey)
+	r.NoError(err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+
+	// the handshake runs in Accept, so keep accepting
+	accepted := make(chan net.Conn, 1)
+	acceptLoop(l, func(c net.Conn) { accepted <- c })
+
+	cli, err := dial(t, l)
+	r.NoError(err)
+	srv := <-accepted
+
+	// a handshake that never completes
+	stalled, err := net.Dial("tcp", netwrap.GetAddr(l.Addr(), "tcp").String())
+	r.NoError(err)
+	defer stalled.Close()
+
+	// wait for the server to start the handshake
+	eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.handshakes) == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(ctx) }()
+
+	// the remote sees a clean end of the stream
+	cli.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = cli.Read(make([]byte, 1))
+	r.Equal(io.EOF, err)
+
+	// the server can't write anymore, but it reads what the remote sends
+	// before its goodbye
+	_, err = srv.Write([]byte("late"))
+	r.True(errors.Is(err, net.ErrClosed), "unexpected error: %v", err)
+
+	_, err = cli.Write([]byte("bye"))
+	r.NoError(err)
+	r.NoError(cli.Close())
+
+	buf, err := io.ReadAll(srv)
+	r.NoError(err)
+	r.Equal("bye", string(buf))
+
+	// the goodbye of the remote ends the shutdown
+	select {
+	case err := <-shutdownErr:
+		r.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown didn't return after the remote's goodbye")
+	}
+	_, err = srv.Read(make([]byte, 1))
+	r.True(errors.Is(err, net.ErrClosed), "unexpected error: %v", err)
+
+	// the pending handshake was aborted
+	stalled.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadAll(stalled)
+	r.NoError(err)
+
+	// no new listeners or connections
+	_, err = s.ListenerWrapper()(l)
+	r.Equal(ErrServerClosed, err)
+
+	a, b := net.Pipe()
+	defer b.Close()
+	_, err = s.ConnWrapper()(a)
+	r.Equal(ErrServerClosed, err)
+}
+
+func TestServerShutdownLocalClose(t *testing.T) {
+	r := require.New(t)
+
+	s, err := NewServer(*serverKeys, appKey)
+	r.NoError(err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+
+	accepted := make(chan net.Conn, 1)
+	acceptLoop(l, func(c net.Conn) { accepted <- c })
+
+	cli, err := dial(t, l)
+	r.NoError(err)
+	defer cli.Close()
+	srv := <-accepted
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(ctx) }()
+
+	// the remote doesn't say goodbye, so Shutdown waits for the application
+	cli.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = cli.Read(make([]byte, 1))
+	r.Equal(io.EOF, err)
+	select {
+	case err := <-shutdownErr:
+		t.Fatal("Shutdown returned before the connection was closed:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.NoError(srv.Close())
+	select {
+	case err := <-shutdownErr:
+		r.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown didn't return after Close")
+	}
+}
+
+func TestServerShutdownForce(t *testing.T) {
+	r := require.New(t)
+
+	s, err := NewServer(*serverKeys, appKey)
+	r.NoError(err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	cli, err := dial(t, l)
+	r.NoError(err)
+	defer cli.Close()
+	srv := <-accepted
+
+	// writes the client never reads fill the socket buffers, so the
+	// goodbye blocks, tiny messages take up the space that large ones leave
+	msg := make([]byte, boxstream.MaxSegmentSize)
+	for _, size := range []int{len(msg), 1} {
+		for {
+			srv.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+			if _, err := srv.Write(msg[:size]); err != nil {
+				break
+			}
+		}
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r.Equal(context.DeadlineExceeded, s.Shutdown(ctx))
+	r.True(time.Since(start) < goodbyeTimeout, "Shutdown waited for the goodbye")
+
+	s.mu.Lock()
+	r.Empty(s.conns)
+	s.mu.Unlock()
+}
+
+func TestServerListenerClose(t *testing.T) {
+	r := require.New(t)
+
+	s, err := NewServer(*serverKeys, appKey)
+	r.NoError(err)
+
+	l, err := netwrap.Listen(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, s.ListenerWrapper())
+	r.NoError(err)
+
+	s.mu.Lock()
+	r.Len(s.listeners, 1)
+	s.mu.Unlock()
+
+	r.NoError(l.Close())
+
+	s.mu.Lock()
+	r.Empty(s.listeners)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r.NoError(s.Shutdown(ctx))
+}
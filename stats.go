@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import "github.com/ssbc/go-secretstream/boxstream"
+
+// ConnStats are the traffic counters of a Conn. Keepalive pings are counted
+// as frames without plaintext.
+type ConnStats struct {
+	Sent, Received boxstream.Stats
+}
+
+// Stats returns the traffic counters of the connection. Frames that were
+// decrypted ahead of Read are counted as received.
+func (conn *Conn) Stats() ConnStats {
+	return ConnStats{
+		Sent:     conn.boxer.Stats(),
+		Received: conn.unboxer.Stats(),
+	}
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2021 The Secretstream Authors
+//
+// SPDX-License-Identifier: MIT
+
+package secretstream
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ssbc/go-secretstream/boxstream"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnStats(t *testing.T) {
+	r := require.New(t)
+
+	srv, cli := mkConnPair(t, nil, nil)
+
+	// spans two frames
+	data := make([]byte, boxstream.MaxSegmentSize+100)
+	_, err := cli.Write(data)
+	r.NoError(err)
+
+	_, err = io.ReadFull(srv, make([]byte, len(data)))
+	r.NoError(err)
+
+	sent := cli.Stats().Sent
+	r.EqualValues(2, sent.Frames)
+	r.EqualValues(len(data), sent.Bytes)
+	r.EqualValues(len(data)+2*boxstream.HeaderLength, sent.WireBytes)
+	r.Equal(boxstream.MaxSegmentSize, sent.LargestFrame)
+	r.Equal(boxstream.Stats{}, cli.Stats().Received)
+
+	r.Equal(sent.Frames, srv.Stats().Received.Frames)
+	r.Equal(sent.WireBytes, srv.Stats().Received.WireBytes)
+	r.EqualValues(0, srv.Stats().Sent.Frames)
+
+	r.NoError(srv.Close())
+	r.NoError(cli.Close())
+}